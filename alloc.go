@@ -0,0 +1,74 @@
+package swisssymbols
+
+import (
+	"unsafe"
+
+	"github.com/philpearl/mmap"
+)
+
+// allocator provides the off-heap memory behind a SymbolTab: the tables, the
+// intbank slabs and the string chunks. By default memory comes directly from
+// the OS. File-backed tables allocate it from a mapped file instead.
+type allocator interface {
+	// allocTable allocates a table. Its groups are not initialised, so that
+	// the caller can choose when to touch the memory.
+	allocTable() *table
+	freeTable(t *table)
+	allocSlab() []int
+	freeSlab(s []int)
+	// allocChunk allocates a chunk for string storage. size is a multiple of
+	// stringbankSize.
+	allocChunk(size int) []byte
+	freeChunk(c []byte)
+}
+
+// anonAllocator allocates anonymous memory directly from the OS. A nil
+// allocator is treated as an anonAllocator.
+type anonAllocator struct{}
+
+var anonMem allocator = anonAllocator{}
+
+func memOrAnon(mem allocator) allocator {
+	if mem == nil {
+		return anonMem
+	}
+	return mem
+}
+
+func (anonAllocator) allocTable() *table {
+	tables, err := mmap.Alloc[table](1)
+	if err != nil {
+		panic(err)
+	}
+	return &tables[0]
+}
+
+func (anonAllocator) freeTable(t *table) {
+	if err := mmap.Free(unsafe.Slice(t, 1)); err != nil {
+		panic(err)
+	}
+}
+
+func (anonAllocator) allocSlab() []int {
+	s, err := mmap.Alloc[int](intbanksize)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func (anonAllocator) freeSlab(s []int) {
+	mmap.Free(s)
+}
+
+func (anonAllocator) allocChunk(size int) []byte {
+	c, err := mmap.Alloc[byte](size)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func (anonAllocator) freeChunk(c []byte) {
+	mmap.Free(c)
+}
@@ -0,0 +1,69 @@
+package swisssymbols
+
+import "unsafe"
+
+const (
+	// batchWindow is how many keys ahead StringsToSequences hashes keys and
+	// prefetches their groups.
+	batchWindow = 8
+	// batchMinTables is the number of tables a SymbolTab needs before
+	// prefetching pays. Smaller SymbolTabs mostly stay in the cache.
+	batchMinTables = 8
+)
+
+// StringsToSequences looks up each of keys, as if by StringToSequence, and
+// sets out[i] to the sequence number of keys[i]. If found is not nil, found[i]
+// is set to whether keys[i] was already present. out, and found if it is not
+// nil, must be at least as long as keys. If addNew is true keys that are not
+// present are added in order, so the result is the same as calling
+// StringToSequence for each key in turn.
+//
+// Looking up a large batch in a large SymbolTab is faster than looking up
+// keys one at a time. Most of the time for a lookup in a large SymbolTab is
+// spent waiting for the key's group to arrive from memory. StringsToSequences
+// hashes each key a few keys ahead of looking it up, and prefetches the first
+// group it will probe, so that the memory loads for several keys are in
+// flight at once. A small SymbolTab stays in the cache, so there is nothing
+// to gain from prefetching, and StringsToSequences doesn't.
+func (m *SymbolTab) StringsToSequences(keys []string, addNew bool, out []uint32, found []bool) {
+	out = out[:len(keys)]
+	if found != nil {
+		found = found[:len(keys)]
+	}
+
+	// We hash each key batchWindow keys before we look it up. hashes holds
+	// the hashes of the keys in between: the hash of keys[i] is in
+	// hashes[i%batchWindow].
+	var hashes [batchWindow]hashValue
+	for i := range len(keys) + batchWindow {
+		var hash hashValue
+		if i < len(keys) {
+			if m.hasher == RuntimeHasher {
+				// As in StringToSequence, this saves a call in the
+				// common case.
+				hash = runtimeHash(keys[i])
+			} else {
+				hash = m.hasher.hash(keys[i])
+			}
+			if m.tableCount >= batchMinTables {
+				m.prefetch(hash)
+			}
+		}
+		if j := i - batchWindow; j >= 0 {
+			seq, f := m.stringToSequence(keys[j], hashes[j%batchWindow], true, addNew)
+			out[j] = seq
+			if found != nil {
+				found[j] = f
+			}
+		}
+		hashes[i%batchWindow] = hash
+	}
+}
+
+// prefetch starts loading the first group probed for hash, so that it is in
+// the cache when we come to probe it. If we add strings the group may have
+// moved by then, but all we lose is the benefit of the prefetch.
+func (m *SymbolTab) prefetch(hash hashValue) {
+	t := m.tables[hash>>hashValue(m.tableIndexShift)]
+	prefetch(unsafe.Pointer(t.groups.getGroup(hash >> 7 & tableMask)))
+}
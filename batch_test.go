@@ -0,0 +1,130 @@
+package swisssymbols
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestStringsToSequences(t *testing.T) {
+	for _, h := range []Hasher{RuntimeHasher, StableHasher} {
+		t.Run(h.String(), func(t *testing.T) {
+			st := New(WithHasher(h))
+			defer st.Close()
+			expected := New(WithHasher(h))
+			defer expected.Close()
+
+			// Batches of all sizes, with repeats within and between batches,
+			// and enough strings to split tables part way through a batch.
+			var keys []string
+			for i := range 300_000 {
+				keys = append(keys, strconv.Itoa(i%200_000))
+			}
+			out := make([]uint32, len(keys))
+			found := make([]bool, len(keys))
+			for start, size := 0, 0; start < len(keys); start, size = start+size, size+1 {
+				batch := keys[start:min(start+size, len(keys))]
+				st.StringsToSequences(batch, true, out, found)
+				for i, key := range batch {
+					seq, f := expected.StringToSequence(key, true)
+					if out[i] != seq || found[i] != f {
+						t.Fatalf("adding %q gave %d, %t, expected %d, %t", key, out[i], found[i], seq, f)
+					}
+				}
+			}
+			assertSameSymbols(t, expected, st)
+
+			// Look up without adding, and without asking about found.
+			keys = append(keys, "missing", "also missing")
+			out = slices.Grow(out, 2)[:len(keys)]
+			st.StringsToSequences(keys, false, out, nil)
+			for i, key := range keys {
+				seq, _ := expected.StringToSequence(key, false)
+				if out[i] != seq {
+					t.Fatalf("looking up %q gave %d, expected %d", key, out[i], seq)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkExistingBatch(b *testing.B) {
+	st := New()
+	defer st.Close()
+	values := make([]string, b.N)
+	for i := range values {
+		values[i] = strconv.Itoa(i)
+	}
+	for _, val := range values {
+		st.StringToSequence(val, true)
+	}
+	out := make([]uint32, 1000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for start := 0; start < len(values); start += len(out) {
+		batch := values[start:min(start+len(out), len(values))]
+		st.StringsToSequences(batch, false, out, nil)
+	}
+
+	if last := out[(len(values)-1)%len(out)]; last != uint32(b.N) {
+		b.Errorf("last symbol doesn't match - got %d", last)
+	}
+}
+
+func BenchmarkMissBatch(b *testing.B) {
+	st := New()
+	defer st.Close()
+	values := make([]string, b.N)
+	for i := range values {
+		values[i] = strconv.Itoa(i)
+	}
+	out := make([]uint32, 1000)
+	found := make([]bool, 1000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for start := 0; start < len(values); start += len(out) {
+		batch := values[start:min(start+len(out), len(values))]
+		st.StringsToSequences(batch, false, out, found)
+		if i := slices.Index(found[:len(batch)], true); i >= 0 {
+			b.Errorf("found value %s", batch[i])
+		}
+	}
+}
+
+// BenchmarkMissLarge looks up strings that are missing from a SymbolTab too
+// big to fit in the cache.
+func BenchmarkMissLarge(b *testing.B) {
+	st := New()
+	defer st.Close()
+	for i := range 4_000_000 {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	values := make([]string, 1<<20)
+	for i := range values {
+		values[i] = "missing" + strconv.Itoa(i)
+	}
+
+	b.Run("single", func(b *testing.B) {
+		for i := range b.N {
+			if _, found := st.StringToSequence(values[i%len(values)], false); found {
+				b.Fatalf("found value %s", values[i%len(values)])
+			}
+		}
+	})
+	b.Run("batch", func(b *testing.B) {
+		out := make([]uint32, 1000)
+		found := make([]bool, 1000)
+		for i := 0; i < b.N; i += len(out) {
+			start := i % len(values)
+			batch := values[start:min(start+len(out), len(values), start+b.N-i)]
+			st.StringsToSequences(batch, false, out, found)
+			if j := slices.Index(found[:len(batch)], true); j >= 0 {
+				b.Fatalf("found value %s", batch[j])
+			}
+		}
+	})
+}
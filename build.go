@@ -0,0 +1,401 @@
+package swisssymbols
+
+import (
+	"cmp"
+	"iter"
+	"math/bits"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/philpearl/mmap"
+)
+
+// buildBucketBits is the number of top hash bits Build uses to split up
+// strings as it reads them. It later groups the buckets into shards.
+const buildBucketBits = 8
+
+// Build builds a SymbolTab from the strings in inputs. Each input is read on
+// its own goroutine, and the strings are then split between shards by the top
+// bits of their hash, which is how the SymbolTab's directory splits them up.
+// Each shard's tables are built on their own goroutine, and the shards are
+// then joined into one SymbolTab.
+//
+// Sequence numbers are given in first-seen order, taking the inputs in turn.
+// The strings from inputs[0] come first, in the order they first appear, then
+// the new strings from inputs[1], and so on. So the result is the same as
+// adding the strings from each input in turn to an empty SymbolTab, however
+// the goroutines are scheduled.
+//
+// Build accepts the same options as New.
+func Build(inputs []iter.Seq[string], opts ...Option) *SymbolTab {
+	return build(inputs, nil, opts)
+}
+
+// BuildSorted is like Build, but gives the strings sequence numbers in the
+// order given by compare. Strings that compare says are equal are given
+// sequence numbers in first-seen order.
+func BuildSorted(inputs []iter.Seq[string], compare func(a, b string) int, opts ...Option) *SymbolTab {
+	return build(inputs, compare, opts)
+}
+
+// buildRecord is a string read by Build. The records don't hold pointers, so
+// the garbage collector doesn't need to scan them.
+type buildRecord struct {
+	// offset is where the string is saved in its input's stringBank
+	offset int
+	// key records where the string was first seen. The input number is in
+	// the top bits and the position in the input in the rest.
+	key  uint64
+	hash hashValue
+	// seq is filled in once we know it
+	seq uint32
+}
+
+const buildKeyPosBits = 40
+
+func (r *buildRecord) input() int {
+	return int(r.key >> buildKeyPosBits)
+}
+
+func (r *buildRecord) pos() int {
+	return int(r.key & (1<<buildKeyPosBits - 1))
+}
+
+// val returns the string for the record
+func (r *buildRecord) val(banks []stringBank) string {
+	return banks[r.input()].Get(r.offset)
+}
+
+// buildShard is the strings that share the shard's top hash bits
+type buildShard struct {
+	records []buildRecord
+	sb      stringBank
+	tables  []*table
+}
+
+func build(inputs []iter.Seq[string], compare func(a, b string) int, opts []Option) *SymbolTab {
+	m := New(opts...)
+
+	// Read the inputs, copying the strings into a stringBank for each input
+	// and splitting them into buckets by hash.
+	buckets := make([][1 << buildBucketBits][]buildRecord, len(inputs))
+	banks := make([]stringBank, len(inputs))
+	defer func() {
+		for i := range banks {
+			banks[i].close()
+		}
+	}()
+	var wg sync.WaitGroup
+	for i, input := range inputs {
+		wg.Go(func() {
+			var pos uint64
+			for val := range input {
+				hash := m.hash(val)
+				b := &buckets[i][hash>>(hashBits-buildBucketBits)]
+				*b = append(*b, buildRecord{offset: banks[i].Save(val), hash: hash, key: uint64(i)<<buildKeyPosBits | pos})
+				pos++
+			}
+		})
+	}
+	wg.Wait()
+
+	// Pick the number of shards so that small tables don't get more tables
+	// than they need.
+	lengths := make([]int, len(inputs))
+	var total int
+	for i := range buckets {
+		for _, b := range buckets[i] {
+			lengths[i] += len(b)
+		}
+		total += lengths[i]
+	}
+	shardBits := min(buildBucketBits, max(0, bits.Len(uint(total/growthThreshold))-1))
+
+	// Remove repeats from each shard. firsts has a bit set for the first time
+	// each string is seen in each input.
+	firsts := make([][]uint64, len(inputs))
+	for i := range firsts {
+		firsts[i] = make([]uint64, (lengths[i]+63)/64)
+	}
+	shards := make([]buildShard, 1<<shardBits)
+	for s := range shards {
+		wg.Go(func() {
+			shard := &shards[s]
+			shard.dedupe(buckets, banks, s, shardBits)
+			if compare != nil {
+				slices.SortFunc(shard.records, func(a, b buildRecord) int {
+					if c := compare(a.val(banks), b.val(banks)); c != 0 {
+						return c
+					}
+					return cmp.Compare(a.key, b.key)
+				})
+				return
+			}
+			for _, r := range shard.records {
+				pos := r.pos()
+				atomic.OrUint64(&firsts[r.input()][pos/64], 1<<(pos%64))
+			}
+		})
+	}
+	wg.Wait()
+	buckets = nil
+
+	if compare != nil {
+		m.count = mergeShards(shards, banks, compare)
+	} else {
+		m.count = rankShards(shards, firsts)
+	}
+
+	// Save the strings, then join the shards' strings into one stringBank.
+	offsets := make([][]int, len(shards))
+	for s := range shards {
+		wg.Go(func() {
+			shard := &shards[s]
+			offsets[s] = make([]int, len(shard.records))
+			for i := range shard.records {
+				offsets[s][i] = shard.sb.Save(shard.records[i].val(banks))
+			}
+		})
+	}
+	wg.Wait()
+
+	for range (m.count + intbanksize - 1) / intbanksize {
+		m.ib.slabs = append(m.ib.slabs, memOrAnon(m.ib.mem).allocSlab())
+	}
+	m.sb.close()
+	bases := make([]int, len(shards))
+	for s := range shards {
+		bases[s] = len(m.sb.chunks) * stringbankSize
+		for _, c := range shards[s].sb.chunks {
+			if c != nil {
+				m.sb.restore(c)
+			}
+		}
+	}
+
+	// Build each shard's tables.
+	for s := range shards {
+		wg.Go(func() {
+			shard := &shards[s]
+			ents := make([]entry, len(shard.records))
+			for i, r := range shard.records {
+				m.ib.save(r.seq, bases[s]+offsets[s][i])
+				ents[i] = entry{hash: r.hash, seq: r.seq}
+			}
+			shard.records = nil
+			shard.buildTables(m.mem, ents, uint16(s), uint16(shardBits))
+		})
+	}
+	wg.Wait()
+
+	m.installTables(shards)
+	if m.readers != nil {
+		m.readers.publish(m)
+	}
+	return m
+}
+
+// dedupe collects the strings for shard s from the buckets, keeping only the
+// first time each string is seen.
+func (shard *buildShard) dedupe(buckets [][1 << buildBucketBits][]buildRecord, banks []stringBank, s, shardBits int) {
+	first := s << (buildBucketBits - shardBits)
+	last := (s + 1) << (buildBucketBits - shardBits)
+
+	var count int
+	for i := range buckets {
+		for _, b := range buckets[i][first:last] {
+			count += len(b)
+		}
+	}
+
+	// index is an open-addressed hash table of positions in records, plus
+	// one so that 0 is empty. The top bits of the hashes are the same for
+	// every string in the shard, so we use the bottom bits.
+	index := make([]uint32, 1<<bits.Len(uint(count*2)))
+	mask := hashValue(len(index) - 1)
+	for i := range buckets {
+		for _, b := range buckets[i][first:last] {
+		next:
+			for _, r := range b {
+				slot := r.hash & mask
+				for ; index[slot] != 0; slot = (slot + 1) & mask {
+					existing := &shard.records[index[slot]-1]
+					if existing.hash == r.hash && existing.val(banks) == r.val(banks) {
+						// A bucket's strings are in the order they were
+						// seen, but the buckets in a shard are not.
+						existing.key = min(existing.key, r.key)
+						continue next
+					}
+				}
+				shard.records = append(shard.records, r)
+				index[slot] = uint32(len(shard.records))
+			}
+		}
+	}
+}
+
+// rankShards gives each string its sequence number in first-seen order. firsts
+// marks the position in each input where each string is first seen, so a
+// string's sequence number is the number of bits set before its own, counting
+// through the inputs in turn. It returns the number of strings.
+func rankShards(shards []buildShard, firsts [][]uint64) int {
+	// ranks[i][j] is the number of strings first seen in the inputs before
+	// input i, plus those first seen in input i before word j of firsts[i].
+	ranks := make([][]uint32, len(firsts))
+	totals := make([]uint32, len(firsts))
+	var wg sync.WaitGroup
+	for i := range firsts {
+		wg.Go(func() {
+			ranks[i] = make([]uint32, len(firsts[i]))
+			var rank uint32
+			for j, w := range firsts[i] {
+				ranks[i][j] = rank
+				rank += uint32(bits.OnesCount64(w))
+			}
+			totals[i] = rank
+		})
+	}
+	wg.Wait()
+
+	var base uint32
+	for i := range ranks {
+		for j := range ranks[i] {
+			ranks[i][j] += base
+		}
+		base += totals[i]
+	}
+
+	for s := range shards {
+		wg.Go(func() {
+			for k := range shards[s].records {
+				r := &shards[s].records[k]
+				i, pos := r.input(), r.pos()
+				r.seq = ranks[i][pos/64] + uint32(bits.OnesCount64(firsts[i][pos/64]&(1<<(pos%64)-1))) + 1
+			}
+		})
+	}
+	wg.Wait()
+	return int(base)
+}
+
+// mergeShards gives each string its sequence number, taking the strings from
+// the shards, which are each sorted by compare, in order. It returns the
+// number of strings.
+func mergeShards(shards []buildShard, banks []stringBank, compare func(a, b string) int) int {
+	// heap is a min-heap of the next string from each shard that still has
+	// strings without sequence numbers.
+	type cursor struct {
+		records []buildRecord
+		next    int
+	}
+	heap := make([]cursor, 0, len(shards))
+	less := func(i, j int) bool {
+		a, b := &heap[i].records[heap[i].next], &heap[j].records[heap[j].next]
+		if c := compare(a.val(banks), b.val(banks)); c != 0 {
+			return c < 0
+		}
+		return a.key < b.key
+	}
+	down := func(i int) {
+		for {
+			smallest := i
+			if l := 2*i + 1; l < len(heap) && less(l, smallest) {
+				smallest = l
+			}
+			if r := 2*i + 2; r < len(heap) && less(r, smallest) {
+				smallest = r
+			}
+			if smallest == i {
+				return
+			}
+			heap[i], heap[smallest] = heap[smallest], heap[i]
+			i = smallest
+		}
+	}
+
+	for s := range shards {
+		if len(shards[s].records) > 0 {
+			heap = append(heap, cursor{records: shards[s].records})
+		}
+	}
+	for i := len(heap)/2 - 1; i >= 0; i-- {
+		down(i)
+	}
+
+	var seq uint32
+	for len(heap) > 0 {
+		c := &heap[0]
+		seq++
+		c.records[c.next].seq = seq
+		c.next++
+		if c.next == len(c.records) {
+			heap[0] = heap[len(heap)-1]
+			heap = heap[:len(heap)-1]
+		}
+		down(0)
+	}
+	return int(seq)
+}
+
+// buildTables puts ents into tables. All the entries share the top depth bits
+// of their hashes, which are index. If there are too many entries for one
+// table we split them between two tables using the next bit.
+func (shard *buildShard) buildTables(mem allocator, ents []entry, index, depth uint16) {
+	if len(ents) <= growthThreshold || depth == 16 {
+		t := memOrAnon(mem).allocTable()
+		t.init()
+		t.localDepth = depth
+		t.index = index
+		for _, ent := range ents {
+			t.insert(ent)
+		}
+		shard.tables = append(shard.tables, t)
+		return
+	}
+
+	bit := hashValue(1) << (hashBits - depth - 1)
+	i, j := 0, len(ents)
+	for i < j {
+		if ents[i].hash&bit == 0 {
+			i++
+			continue
+		}
+		j--
+		ents[i], ents[j] = ents[j], ents[i]
+	}
+	shard.buildTables(mem, ents[:i], index*2, depth+1)
+	shard.buildTables(mem, ents[i:], index*2+1, depth+1)
+}
+
+// installTables replaces the SymbolTab's directory with one holding the
+// shards' tables.
+func (m *SymbolTab) installTables(shards []buildShard) {
+	for i, t := range m.tables {
+		if i > 0 && m.tables[i-1] == t {
+			continue
+		}
+		m.freeTable(t)
+	}
+	mmap.Free(m.tables)
+
+	m.tableIndexDepth = 0
+	for s := range shards {
+		for _, t := range shards[s].tables {
+			m.tableIndexDepth = max(m.tableIndexDepth, t.localDepth)
+		}
+	}
+	m.tableIndexShift = hashBits - m.tableIndexDepth
+
+	var err error
+	m.tables, err = mmap.Alloc[*table](1 << m.tableIndexDepth)
+	if err != nil {
+		panic(err)
+	}
+	for s := range shards {
+		for _, t := range shards[s].tables {
+			m.insertTable(t)
+			m.tableCount++
+		}
+	}
+}
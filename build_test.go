@@ -0,0 +1,163 @@
+package swisssymbols
+
+import (
+	"iter"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// buildInputs returns n inputs. Each has count strings, many of which are
+// repeated within the input and also appear in other inputs.
+func buildInputs(n, count int) []iter.Seq[string] {
+	inputs := make([]iter.Seq[string], n)
+	for p := range inputs {
+		inputs[p] = func(yield func(string) bool) {
+			for i := range count {
+				j := (i*7 + p*count/2) % (count * n / 2)
+				if j%50 == 0 {
+					j = 0
+				}
+				val := strconv.Itoa(j)
+				if j%100 == 1 {
+					// Some long strings
+					val = strings.Repeat(val, 100)
+				}
+				if !yield(val) {
+					return
+				}
+			}
+		}
+	}
+	return inputs
+}
+
+func TestBuild(t *testing.T) {
+	tests := []struct {
+		name         string
+		inputs       int
+		count        int
+		expectTables int
+	}{
+		{name: "empty", inputs: 0, expectTables: 1},
+		{name: "small", inputs: 3, count: 100, expectTables: 1},
+		{name: "large", inputs: 4, count: 200_000, expectTables: 32},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inputs := buildInputs(test.inputs, test.count)
+			st := Build(inputs)
+			defer st.Close()
+
+			expected := New()
+			defer expected.Close()
+			for _, input := range inputs {
+				for val := range input {
+					expected.StringToSequence(val, true)
+				}
+			}
+			assertSameSymbols(t, expected, st)
+			if st.tableCount != test.expectTables {
+				t.Errorf("expected %d tables, have %d", test.expectTables, st.tableCount)
+			}
+
+			// The built table carries on working as normal.
+			for i := range 100_000 {
+				val := "new" + strconv.Itoa(i)
+				expected.StringToSequence(val, true)
+				if _, found := st.StringToSequence(val, true); found {
+					t.Fatalf("new string %q already present", val)
+				}
+			}
+			assertSameSymbols(t, expected, st)
+		})
+	}
+}
+
+func TestBuildHasher(t *testing.T) {
+	inputs := buildInputs(2, 100_000)
+	st := Build(inputs, WithHasher(StableHasher))
+	defer st.Close()
+	expected := New()
+	defer expected.Close()
+	for _, input := range inputs {
+		for val := range input {
+			expected.StringToSequence(val, true)
+		}
+	}
+	assertSameSymbols(t, expected, st)
+}
+
+func TestBuildSorted(t *testing.T) {
+	inputs := buildInputs(4, 100_000)
+	st := BuildSorted(inputs, strings.Compare)
+	defer st.Close()
+
+	var vals []string
+	for _, input := range inputs {
+		vals = slices.AppendSeq(vals, input)
+	}
+	slices.Sort(vals)
+	vals = slices.Compact(vals)
+
+	if st.Len() != len(vals) {
+		t.Fatalf("expected %d strings, have %d", len(vals), st.Len())
+	}
+	for i, val := range vals {
+		if got := st.SequenceToString(uint32(i + 1)); got != val {
+			t.Fatalf("expected %q for seq %d, got %q", val, i+1, got)
+		}
+		if seq, found := st.StringToSequence(val, false); !found || seq != uint32(i+1) {
+			t.Fatalf("looking up %q gave %d, %t", val, seq, found)
+		}
+	}
+}
+
+func TestBuildSortedTies(t *testing.T) {
+	// Ordering by length leaves many ties, which are broken by first-seen
+	// order.
+	inputs := []iter.Seq[string]{
+		slices.Values([]string{"ccc", "a", "bb", "b"}),
+		slices.Values([]string{"dd", "a", "e", "ffff"}),
+	}
+	st := BuildSorted(inputs, func(a, b string) int { return len(a) - len(b) })
+	defer st.Close()
+
+	expected := []string{"a", "b", "e", "bb", "dd", "ccc", "ffff"}
+	if st.Len() != len(expected) {
+		t.Fatalf("expected %d strings, have %d", len(expected), st.Len())
+	}
+	for i, val := range expected {
+		if got := st.SequenceToString(uint32(i + 1)); got != val {
+			t.Errorf("expected %q for seq %d, got %q", val, i+1, got)
+		}
+	}
+}
+
+func BenchmarkBuild(b *testing.B) {
+	const count = 1 << 20
+	vals := make([]string, count)
+	for i := range vals {
+		vals[i] = strconv.Itoa(i)
+	}
+	inputs := make([]iter.Seq[string], 8)
+	for i := range inputs {
+		inputs[i] = slices.Values(vals[i*count/len(inputs) : (i+1)*count/len(inputs)])
+	}
+
+	b.Run("sequential", func(b *testing.B) {
+		for range b.N {
+			st := New()
+			for _, val := range vals {
+				st.StringToSequence(val, true)
+			}
+			st.Close()
+		}
+	})
+	b.Run("build", func(b *testing.B) {
+		for range b.N {
+			Build(inputs).Close()
+		}
+	})
+}
@@ -0,0 +1,20 @@
+package swisssymbols
+
+import "unsafe"
+
+// BytesToSequence is like StringToSequence, but takes the string as a byte
+// slice. It doesn't allocate: key is hashed and compared where it is, and only
+// copied if it is added to the SymbolTab. The SymbolTab doesn't keep a
+// reference to key, so the caller may reuse it straight away.
+func (m *SymbolTab) BytesToSequence(key []byte, addNew bool) (seq uint32, found bool) {
+	// StringToSequence copies val into the stringBank when it adds it, and
+	// doesn't hold on to val otherwise.
+	return m.StringToSequence(unsafe.String(unsafe.SliceData(key), len(key)), addNew)
+}
+
+// AppendSequenceTo appends the string with sequence number seq to dst and
+// returns the extended slice. It is the counterpart of BytesToSequence, and
+// is like SequenceToString but copies the string.
+func (m *SymbolTab) AppendSequenceTo(dst []byte, seq uint32) []byte {
+	return append(dst, m.SequenceToString(seq)...)
+}
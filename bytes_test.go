@@ -0,0 +1,61 @@
+package swisssymbols
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestBytesToSequence(t *testing.T) {
+	for _, h := range []Hasher{RuntimeHasher, StableHasher} {
+		t.Run(h.String(), func(t *testing.T) {
+			st := New(WithHasher(h))
+			defer st.Close()
+
+			// We use one buffer for every key, as a parser might.
+			var key []byte
+			for i := range 100_000 {
+				key = strconv.AppendInt(key[:0], int64(i), 10)
+				if seq, found := st.BytesToSequence(key, true); found || seq != uint32(i+1) {
+					t.Fatalf("adding %q gave %d, %t", key, seq, found)
+				}
+			}
+			var val []byte
+			for i := range 100_000 {
+				key = strconv.AppendInt(key[:0], int64(i), 10)
+				if seq, found := st.BytesToSequence(key, false); !found || seq != uint32(i+1) {
+					t.Fatalf("looking up %q gave %d, %t", key, seq, found)
+				}
+				if seq, found := st.StringToSequence(string(key), false); !found || seq != uint32(i+1) {
+					t.Fatalf("looking up %q as a string gave %d, %t", key, seq, found)
+				}
+				val = st.AppendSequenceTo(val[:0], uint32(i+1))
+				if string(val) != string(key) {
+					t.Fatalf("seq %d gives %q", i+1, val)
+				}
+			}
+			if _, found := st.BytesToSequence([]byte("missing"), false); found {
+				t.Fatal("found a missing key")
+			}
+			if seq, found := st.BytesToSequence(nil, true); found || seq != 100_001 {
+				t.Fatalf("adding an empty key gave %d, %t", seq, found)
+			}
+		})
+	}
+}
+
+func TestBytesToSequenceAllocs(t *testing.T) {
+	st := New()
+	defer st.Close()
+	key := []byte("hello")
+	st.BytesToSequence(key, true)
+	buf := make([]byte, 0, 16)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		st.BytesToSequence(key, true)
+		st.BytesToSequence([]byte("missing"), false)
+		buf = st.AppendSequenceTo(buf[:0], 1)
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations, got %f", allocs)
+	}
+}
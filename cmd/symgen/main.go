@@ -0,0 +1,256 @@
+// Command symgen generates Go source for a fixed vocabulary of symbols.
+//
+// It reads a word list with one word per line. Blank lines and lines starting
+// with # are ignored, as is whitespace around each word. For each word it
+// writes a typed constant holding the word's sequence number, and it writes a
+// prebuilt swisssymbols.Image that maps between the constants and the words
+// without inserting anything at startup.
+//
+// Sequence numbers are stable. If the output file already exists, symgen
+// reads the words from it first and keeps their sequence numbers. New words
+// from the list get the following sequence numbers. Words are never removed,
+// so a word that is taken out of the list keeps its constant.
+//
+// Usage:
+//
+//	symgen -type Event [-o event_symbols.go] [-package events] words.txt
+//
+// It is usually run from a go:generate directive, like
+//
+//	//go:generate go run github.com/philpearl/swisssymbols/cmd/symgen -type Event events.txt
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/philpearl/swisssymbols"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "symgen:", err)
+		os.Exit(1)
+	}
+}
+
+type config struct {
+	typeName string
+	pkg      string
+	output   string
+	input    string
+}
+
+// imageName is the name of the constant that holds the image in the
+// generated code.
+func (c config) imageName() string {
+	return "_" + c.typeName + "Image"
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("symgen", flag.ContinueOnError)
+	var c config
+	fs.StringVar(&c.typeName, "type", "", "name of the Go type for the symbols (required)")
+	fs.StringVar(&c.pkg, "package", os.Getenv("GOPACKAGE"), "package name for the generated code")
+	fs.StringVar(&c.output, "o", "", "output file (default <type>_symbols.go in lower case)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected one word list file, got %d arguments", fs.NArg())
+	}
+	c.input = fs.Arg(0)
+	if !token.IsIdentifier(c.typeName) || !token.IsExported(c.typeName) {
+		return fmt.Errorf("-type %q is not an exported Go identifier", c.typeName)
+	}
+	if !token.IsIdentifier(c.pkg) {
+		return fmt.Errorf("-package %q is not a valid package name", c.pkg)
+	}
+	if c.output == "" {
+		c.output = strings.ToLower(c.typeName) + "_symbols.go"
+	}
+
+	f, err := os.Open(c.input)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	words, err := readWords(f)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", c.input, err)
+	}
+
+	existing, err := readExisting(c.output, c.imageName())
+	if err != nil {
+		return fmt.Errorf("reading existing words from %s: %w", c.output, err)
+	}
+
+	src, err := generate(c, append(existing, words...))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.output, src, 0o644)
+}
+
+// readWords reads a word list
+func readWords(r io.Reader) ([]string, error) {
+	var words []string
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		word := strings.TrimSpace(s.Text())
+		if word == "" || word[0] == '#' {
+			continue
+		}
+		words = append(words, word)
+	}
+	return words, s.Err()
+}
+
+// readExisting reads the words from a file we generated previously, in
+// sequence order. It returns no words if the file does not exist.
+func readExisting(path, imageName string) ([]string, error) {
+	src, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := parser.ParseFile(token.NewFileSet(), path, src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	var lit *ast.BasicLit
+	ast.Inspect(f, func(n ast.Node) bool {
+		spec, ok := n.(*ast.ValueSpec)
+		if !ok {
+			return true
+		}
+		for i, name := range spec.Names {
+			if name.Name == imageName && i < len(spec.Values) {
+				lit, _ = spec.Values[i].(*ast.BasicLit)
+			}
+		}
+		return false
+	})
+	if lit == nil || lit.Kind != token.STRING {
+		return nil, fmt.Errorf("no string constant %s", imageName)
+	}
+	data, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return nil, err
+	}
+	im, err := swisssymbols.LoadImageString(data)
+	if err != nil {
+		return nil, err
+	}
+
+	words := make([]string, im.Len())
+	for i := range words {
+		words[i] = im.SequenceToString(uint32(i + 1))
+	}
+	return words, nil
+}
+
+// generate returns the generated source for words. Each word's sequence
+// number is its position in words, ignoring repeats.
+func generate(c config, words []string) ([]byte, error) {
+	st := swisssymbols.New()
+	defer st.Close()
+	for _, word := range words {
+		st.StringToSequence(word, true)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by symgen -type %s %s. DO NOT EDIT.\n\n", c.typeName, c.input)
+	fmt.Fprintf(&buf, "package %s\n\n", c.pkg)
+	fmt.Fprintf(&buf, "import \"github.com/philpearl/swisssymbols\"\n\n")
+
+	fmt.Fprintf(&buf, "// %s is a symbol from a fixed vocabulary. Its value is the symbol's\n", c.typeName)
+	fmt.Fprintf(&buf, "// sequence number in %sSymbols.\n", c.typeName)
+	fmt.Fprintf(&buf, "type %s uint32\n\n", c.typeName)
+
+	fmt.Fprintf(&buf, "const (\n")
+	// The constants share the package scope with the names below, so words
+	// must not give those names either.
+	reserved := map[string]bool{
+		c.typeName + "Symbols": true,
+		"Parse" + c.typeName:   true,
+		c.imageName():          true,
+	}
+	names := make(map[string]string, st.Len())
+	for seq := uint32(1); seq <= uint32(st.Len()); seq++ {
+		word := st.SequenceToString(seq)
+		name, err := identifier(c.typeName, word)
+		if err != nil {
+			return nil, err
+		}
+		if reserved[name] {
+			return nil, fmt.Errorf("word %q gives the name %s, which symgen uses for something else", word, name)
+		}
+		if other, ok := names[name]; ok {
+			return nil, fmt.Errorf("words %q and %q both give the name %s", other, word, name)
+		}
+		names[name] = word
+		fmt.Fprintf(&buf, "\t%s %s = %d // %s\n", name, c.typeName, seq, strconv.Quote(word))
+	}
+	fmt.Fprintf(&buf, ")\n\n")
+
+	fmt.Fprintf(&buf, "// %sSymbols maps between %s values and their strings.\n", c.typeName, c.typeName)
+	fmt.Fprintf(&buf, "var %sSymbols = func() *swisssymbols.Image {\n", c.typeName)
+	fmt.Fprintf(&buf, "\tim, err := swisssymbols.LoadImageString(%s)\n", c.imageName())
+	fmt.Fprintf(&buf, "\tif err != nil {\n\t\tpanic(err)\n\t}\n\treturn im\n}()\n\n")
+
+	fmt.Fprintf(&buf, "// String returns the string for the %s\n", c.typeName)
+	fmt.Fprintf(&buf, "func (v %s) String() string {\n", c.typeName)
+	fmt.Fprintf(&buf, "\treturn %sSymbols.SequenceToString(uint32(v))\n}\n\n", c.typeName)
+
+	fmt.Fprintf(&buf, "// Parse%s returns the %s for s. ok is false if s is not in the vocabulary.\n", c.typeName, c.typeName)
+	fmt.Fprintf(&buf, "func Parse%s(s string) (v %s, ok bool) {\n", c.typeName, c.typeName)
+	fmt.Fprintf(&buf, "\tseq, ok := %sSymbols.StringToSequence(s, false)\n", c.typeName)
+	fmt.Fprintf(&buf, "\treturn %s(seq), ok\n}\n\n", c.typeName)
+
+	var image bytes.Buffer
+	if _, err := st.WritePerfectImage(&image); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(&buf, "const %s = %s\n", c.imageName(), strconv.Quote(image.String()))
+
+	return format.Source(buf.Bytes())
+}
+
+// identifier makes a Go identifier for word by joining prefix with the
+// letters and digits in word, capitalising the start of each run.
+func identifier(prefix, word string) (string, error) {
+	var b strings.Builder
+	b.WriteString(prefix)
+	upper := true
+	for _, r := range word {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == len(prefix) {
+		return "", fmt.Errorf("cannot make a name for %q as it has no letters or digits", word)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStableIDs(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "words.txt")
+	output := filepath.Join(dir, "event_symbols.go")
+	args := []string{"-type", "Event", "-package", "events", "-o", output, input}
+
+	write := func(words string) {
+		t.Helper()
+		if err := os.WriteFile(input, []byte(words), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := run(args); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("# events\nlogin\n\n  logout  \nuser.created\n")
+	words, err := readExisting(output, "_EventImage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(words, ","); got != "login,logout,user.created" {
+		t.Fatalf("unexpected words %s", got)
+	}
+
+	// Adding words to the list, reordering it, and removing words doesn't
+	// change existing IDs
+	write("user.created\nhttp_requests_total\nlogin\n")
+	words, err = readExisting(output, "_EventImage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(words, ","); got != "login,logout,user.created,http_requests_total" {
+		t.Fatalf("unexpected words %s", got)
+	}
+
+	src, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"// Code generated by symgen",
+		"package events\n",
+		`EventLogin             Event = 1 // "login"`,
+		`EventHttpRequestsTotal Event = 4 // "http_requests_total"`,
+		"func ParseEvent(s string) (v Event, ok bool) {",
+	} {
+		if !strings.Contains(string(src), expected) {
+			t.Fatalf("expected output to contain %q. Output is\n%s", expected, src)
+		}
+	}
+}
+
+func TestIdentifier(t *testing.T) {
+	tests := []struct {
+		word string
+		exp  string
+		err  string
+	}{
+		{word: "login", exp: "EventLogin"},
+		{word: "user.created", exp: "EventUserCreated"},
+		{word: "http_requests_total", exp: "EventHttpRequestsTotal"},
+		{word: "2xx", exp: "Event2xx"},
+		{word: "café au lait", exp: "EventCaféAuLait"},
+		{word: "...", err: `cannot make a name for "..." as it has no letters or digits`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.word, func(t *testing.T) {
+			name, err := identifier("Event", tt.word)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != tt.exp {
+				t.Fatalf("expected %s, got %s", tt.exp, name)
+			}
+		})
+	}
+}
+
+func TestNameClash(t *testing.T) {
+	_, err := generate(config{typeName: "Event", pkg: "events"}, []string{"user.created", "user_created"})
+	if err == nil || err.Error() != `words "user.created" and "user_created" both give the name EventUserCreated` {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestReservedName(t *testing.T) {
+	tests := []struct {
+		typeName string
+		word     string
+		exp      string
+	}{
+		{typeName: "Event", word: "symbols", exp: `word "symbols" gives the name EventSymbols, which symgen uses for something else`},
+		{typeName: "Parse", word: "parse", exp: `word "parse" gives the name ParseParse, which symgen uses for something else`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.word, func(t *testing.T) {
+			_, err := generate(config{typeName: tt.typeName, pkg: "events"}, []string{"hat", tt.word})
+			if err == nil || err.Error() != tt.exp {
+				t.Fatalf("unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestGeneratedCodeBuilds(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a module with the go command")
+	}
+	goCmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	root, err := filepath.Abs("../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Generate a package in its own module that uses this copy of
+	// swisssymbols, then test it with the go command.
+	dir := t.TempDir()
+	input := filepath.Join(dir, "words.txt")
+	files := map[string]string{
+		"words.txt": "login\nlogout\nuser.created\nhttp_requests_total\n",
+		"go.mod": "module example.com/events\n\ngo 1.25.0\n\n" +
+			"require github.com/philpearl/swisssymbols v0.0.0\n\n" +
+			"replace github.com/philpearl/swisssymbols => " + root + "\n",
+		"event_test.go": `package events
+
+import "testing"
+
+func TestEvent(t *testing.T) {
+	if s := EventUserCreated.String(); s != "user.created" {
+		t.Fatalf("expected user.created, got %q", s)
+	}
+	if v, ok := ParseEvent("http_requests_total"); !ok || v != EventHttpRequestsTotal {
+		t.Fatalf("expected EventHttpRequestsTotal, got %d, %t", v, ok)
+	}
+	if _, ok := ParseEvent("logoff"); ok {
+		t.Fatal("did not expect to parse logoff")
+	}
+}
+`,
+	}
+	if sum, err := os.ReadFile(filepath.Join(root, "go.sum")); err == nil {
+		files["go.sum"] = string(sum)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := run([]string{"-type", "Event", "-package", "events", "-o", filepath.Join(dir, "event_symbols.go"), input}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{{"vet", "."}, {"test", "."}} {
+		cmd := exec.Command(goCmd, args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("go %s failed: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package swisssymbols
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+	"strings"
+	"unsafe"
+)
+
+// A compact snapshot has the same header as other snapshots, with version 3
+// and base 0. The body is
+//
+//	blockSize uvarint
+//	seqs      count x uvarint, the sequence number of each string in sorted order
+//	strings   count strings in sorted order
+//	checksum  uint32 CRC-32C of everything above
+//
+// The strings are front-coded in blocks of blockSize strings. The first string
+// in each block is written as a uvarint length followed by the bytes. Each
+// following string is written as the uvarint length of the prefix it shares
+// with the string before it, then the uvarint length of the rest of the
+// string, then the rest of the string.
+const compactBlockSize = 16
+
+// WriteCompactTo writes a snapshot of the SymbolTab to w in a compact format.
+// It sorts the strings and stores only the part of each string that differs
+// from the one before, so it is much smaller than the snapshot written by
+// WriteTo if many strings share prefixes. Load it with ReadFrom or
+// UnmarshalBinary.
+//
+// Writing a compact snapshot is slower than WriteTo, as the strings are
+// sorted. Loading it with ReadFrom is faster than loading either WriteTo's
+// snapshot or ImportText's text, because the sort order shows the strings are
+// unique, so they need not be looked up as they are added.
+func (m *SymbolTab) WriteCompactTo(w io.Writer) (n int64, err error) {
+	if m.deleted > 0 {
+		return 0, ErrHasDeletions
+	}
+	sorted := make([]uint32, m.count)
+	for i := range sorted {
+		sorted[i] = uint32(i + 1)
+	}
+	slices.SortFunc(sorted, func(a, b uint32) int {
+		return strings.Compare(m.SequenceToString(a), m.SequenceToString(b))
+	})
+
+	crc := crc32.New(crcTable)
+	cw := &countingWriter{w: io.MultiWriter(w, crc)}
+	bw := bufio.NewWriter(cw)
+
+	var hdr [snapshotHeaderSize]byte
+	copy(hdr[:4], snapshotMagic)
+	binary.LittleEndian.PutUint32(hdr[4:], snapshotCompactVersion)
+	binary.LittleEndian.PutUint64(hdr[16:], uint64(m.count))
+	bw.Write(hdr[:])
+
+	var buf [binary.MaxVarintLen64]byte
+	writeUvarint := func(v int) {
+		bw.Write(buf[:binary.PutUvarint(buf[:], uint64(v))])
+	}
+	writeUvarint(compactBlockSize)
+	for _, seq := range sorted {
+		writeUvarint(int(seq))
+	}
+
+	var prev string
+	for i, seq := range sorted {
+		val := m.SequenceToString(seq)
+		if i%compactBlockSize != 0 {
+			shared := sharedPrefix(prev, val)
+			writeUvarint(shared)
+			val = val[shared:]
+		}
+		writeUvarint(len(val))
+		bw.WriteString(val)
+		prev = m.SequenceToString(seq)
+	}
+	if err := bw.Flush(); err != nil {
+		return cw.n, err
+	}
+
+	binary.LittleEndian.PutUint32(buf[:], crc.Sum32())
+	l, err := w.Write(buf[:4])
+	return cw.n + int64(l), err
+}
+
+func sharedPrefix(a, b string) int {
+	l := min(len(a), len(b))
+	for i := range l {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return l
+}
+
+// readCompact loads the body of a compact snapshot into an empty SymbolTab.
+// If there's an error the SymbolTab must be reset.
+func (m *SymbolTab) readCompact(sr *snapshotReader, count uint64) error {
+	// The strings arrive in sorted order. We save each one and take its hash
+	// as it arrives, while it is in the cache. Then we add the entries to the
+	// tables in sequence order, prefetching groups a few entries ahead as
+	// StringsToSequences does. Most of the time for each entry is spent
+	// waiting for its group, and prefetching lets us wait for several at
+	// once.
+	var hashes []hashValue
+	if err := sr.readCompact(count, func(seq uint32, val []byte) {
+		if hashes == nil {
+			// By now readCompact has read a sequence number for every
+			// string, so we know count isn't larger than the input.
+			hashes = make([]hashValue, count)
+		}
+		v := unsafe.String(unsafe.SliceData(val), len(val))
+		m.ib.save(seq, m.sb.Save(v))
+		hashes[seq-1] = m.hash(v)
+	}); err != nil {
+		return err
+	}
+
+	for i := range min(batchWindow, len(hashes)) {
+		m.prefetch(hashes[i])
+	}
+	for i, hash := range hashes {
+		// We know from the sort order that the strings are unique.
+		m.insertNew(uint32(i+1), hash)
+		if ahead := i + batchWindow; ahead < len(hashes) {
+			m.prefetch(hashes[ahead])
+		}
+	}
+
+	m.count = int(count)
+	if m.readers != nil {
+		m.readers.publish(m)
+	}
+	return nil
+}
+
+// applyCompact loads the body of a compact snapshot into an empty SymbolTab.
+// Unlike readCompact, it reads and checks the whole snapshot before changing
+// the SymbolTab.
+func (m *SymbolTab) applyCompact(sr *snapshotReader, count uint64) error {
+	cs, err := sr.readCompactStrings(count)
+	if err != nil {
+		return err
+	}
+	for i := range cs.starts {
+		m.StringToSequence(cs.get(i), true)
+	}
+	return nil
+}
+
+// compactStrings holds the strings from a compact snapshot in one buffer.
+// String i, with sequence number i+1, is data[starts[i]:ends[i]].
+type compactStrings struct {
+	data         []byte
+	starts, ends []int
+}
+
+func (cs *compactStrings) get(i int) string {
+	b := cs.data[cs.starts[i]:cs.ends[i]]
+	return unsafe.String(unsafe.SliceData(b), len(b))
+}
+
+// readCompactStrings reads and checks the body of a compact snapshot.
+func (s *snapshotReader) readCompactStrings(count uint64) (*compactStrings, error) {
+	var cs compactStrings
+	if err := s.readCompact(count, func(seq uint32, val []byte) {
+		if cs.starts == nil {
+			// We allocate these here rather than up front because by now
+			// readCompact has read a sequence number for every string, so
+			// we know count isn't larger than the input.
+			cs.starts, cs.ends = make([]int, count), make([]int, count)
+		}
+		cs.starts[seq-1] = len(cs.data)
+		cs.data = append(cs.data, val...)
+		cs.ends[seq-1] = len(cs.data)
+	}); err != nil {
+		return nil, err
+	}
+	return &cs, nil
+}
+
+// readCompact reads the body of a compact snapshot, calling fn with each
+// string and its sequence number. The strings are in sorted order, not
+// sequence order, and val is only valid during the call. readCompact checks
+// that the sequence numbers run from 1 to count and the strings are unique.
+func (s *snapshotReader) readCompact(count uint64, fn func(seq uint32, val []byte)) error {
+	blockSize, err := s.readUvarint()
+	if err != nil {
+		return fmt.Errorf("reading block size: %w", err)
+	}
+	if blockSize == 0 {
+		return errors.New("invalid block size 0")
+	}
+
+	// The count comes from the header, which we haven't checked yet. We grow
+	// seqs as we read rather than trust it, and only allocate seen once we
+	// know there really are count sequence numbers.
+	var seqs []uint32
+	for i := range count {
+		seq, err := s.readUvarint()
+		if err != nil {
+			return fmt.Errorf("reading sequence number %d: %w", i+1, err)
+		}
+		if seq == 0 || seq > count {
+			return fmt.Errorf("invalid sequence number %d", seq)
+		}
+		seqs = append(seqs, uint32(seq))
+	}
+	seen := make([]uint64, (count+63)/64)
+	for _, seq := range seqs {
+		if seen[(seq-1)/64]&(1<<((seq-1)%64)) != 0 {
+			return fmt.Errorf("invalid sequence number %d", seq)
+		}
+		seen[(seq-1)/64] |= 1 << ((seq - 1) % 64)
+	}
+
+	var prev, cur []byte
+	for i := range count {
+		var shared uint64
+		if i%blockSize != 0 {
+			if shared, err = s.readUvarint(); err != nil {
+				return fmt.Errorf("reading prefix length of string %d: %w", i+1, err)
+			}
+			if shared > uint64(len(prev)) {
+				return fmt.Errorf("string %d has invalid prefix length %d", i+1, shared)
+			}
+		}
+		l, err := s.readLength(i)
+		if err != nil {
+			return err
+		}
+		if shared+l > maxStringLen {
+			return fmt.Errorf("string %d has invalid length %d", i+1, shared+l)
+		}
+
+		cur = append(cur[:0], prev[:shared]...)
+		if cur, err = s.appendN(cur, l); err != nil {
+			return fmt.Errorf("reading string %d: %w", i+1, err)
+		}
+		// The strings must be in strictly increasing order, which also means
+		// they are unique. We only need to compare the parts after the shared
+		// prefix.
+		if i > 0 && bytes.Compare(cur[shared:], prev[shared:]) <= 0 {
+			return fmt.Errorf("string %d is out of order or a duplicate", i+1)
+		}
+
+		fn(seqs[i], cur)
+		prev, cur = cur, prev
+	}
+
+	return s.checkChecksum()
+}
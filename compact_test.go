@@ -0,0 +1,250 @@
+package swisssymbols
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func TestCompactRoundTrip(t *testing.T) {
+	st := New()
+	defer st.Close()
+	for _, val := range awkwardStrings {
+		st.StringToSequence(val, true)
+	}
+	// Strings that share long prefixes, added in an order unrelated to their
+	// sort order.
+	for i := range 10_000 {
+		st.StringToSequence(fmt.Sprintf("https://example.com/api/v1/users/%d/profile", (i*7919)%10_000), true)
+	}
+
+	var compact, plain bytes.Buffer
+	n, err := st.WriteCompactTo(&compact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(compact.Len()) {
+		t.Fatalf("wrote %d bytes, but returned %d", compact.Len(), n)
+	}
+	if _, err := st.WriteTo(&plain); err != nil {
+		t.Fatal(err)
+	}
+	if compact.Len() >= plain.Len()/2 {
+		t.Fatalf("expected compact snapshot (%d bytes) to be much smaller than plain (%d bytes)", compact.Len(), plain.Len())
+	}
+
+	t.Run("ReadFrom", func(t *testing.T) {
+		var loaded SymbolTab
+		defer loaded.Close()
+		n, err := loaded.ReadFrom(bytes.NewReader(compact.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(compact.Len()) {
+			t.Fatalf("read %d bytes, expected %d", n, compact.Len())
+		}
+		assertSameSymbols(t, st, &loaded)
+
+		// We can carry on adding strings
+		if seq, found := loaded.StringToSequence("new", true); found || seq != uint32(st.Len()+1) {
+			t.Fatalf("expected new string at %d, got %d (found=%t)", st.Len()+1, seq, found)
+		}
+	})
+
+	t.Run("ApplyDelta", func(t *testing.T) {
+		loaded := New()
+		defer loaded.Close()
+		if err := loaded.ApplyDelta(bytes.NewReader(compact.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+		assertSameSymbols(t, st, loaded)
+	})
+}
+
+func TestCompactEmpty(t *testing.T) {
+	st := New()
+	defer st.Close()
+	data := compactSnapshot(t, st)
+
+	var loaded SymbolTab
+	defer loaded.Close()
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Len() != 0 {
+		t.Fatalf("expected no strings, got %d", loaded.Len())
+	}
+}
+
+func TestCompactErrors(t *testing.T) {
+	st := New()
+	defer st.Close()
+	st.StringToSequence("hat", true)
+	good := compactSnapshot(t, st)
+
+	// build makes a compact snapshot with block size 16 from its parts
+	build := func(seqs []byte, body []byte) []byte {
+		var buf bytes.Buffer
+		buf.Write(good[:16])
+		buf.Write(binary.LittleEndian.AppendUint64(nil, uint64(len(seqs))))
+		buf.WriteByte(16)
+		buf.Write(seqs)
+		buf.Write(body)
+		buf.Write(binary.LittleEndian.AppendUint32(nil, crc32Of(buf.Bytes())))
+		return buf.Bytes()
+	}
+
+	corrupt := bytes.Clone(good)
+	corrupt[len(corrupt)-6] ^= 0x01
+
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{
+			name: "valid",
+			data: build([]byte{2, 1}, []byte{3, 'c', 'a', 't', 1, 2, 'o', 't'}),
+		},
+		{
+			name: "checksum",
+			data: corrupt,
+			err:  ErrBadChecksum.Error(),
+		},
+		{
+			name: "repeated seq",
+			data: build([]byte{1, 1}, []byte{3, 'c', 'a', 't', 1, 2, 'o', 't'}),
+			err:  "invalid sequence number 1",
+		},
+		{
+			name: "seq too big",
+			data: build([]byte{1, 3}, []byte{3, 'c', 'a', 't', 1, 2, 'o', 't'}),
+			err:  "invalid sequence number 3",
+		},
+		{
+			name: "duplicate",
+			data: build([]byte{1, 2}, []byte{3, 'c', 'a', 't', 3, 0}),
+			err:  "string 2 is out of order or a duplicate",
+		},
+		{
+			name: "out of order",
+			data: build([]byte{1, 2}, []byte{3, 'c', 'o', 't', 1, 2, 'a', 't'}),
+			err:  "string 2 is out of order or a duplicate",
+		},
+		{
+			name: "prefix too long",
+			data: build([]byte{1, 2}, []byte{3, 'c', 'a', 't', 4, 1, 's'}),
+			err:  "string 2 has invalid prefix length 4",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, load := range []func(st *SymbolTab) error{
+				func(st *SymbolTab) error { return st.UnmarshalBinary(tt.data) },
+				func(st *SymbolTab) error { return st.ApplyDelta(bytes.NewReader(tt.data)) },
+			} {
+				loaded := New()
+				err := load(loaded)
+				if tt.err == "" {
+					if err != nil {
+						t.Fatal(err)
+					}
+					if s := loaded.SequenceToString(1); s != "cot" {
+						t.Fatalf("expected cot at 1, got %q", s)
+					}
+				} else {
+					if err == nil || err.Error() != tt.err {
+						t.Fatalf("expected error %q, got %v", tt.err, err)
+					}
+					if loaded.Len() != 0 {
+						t.Fatalf("expected table to be empty after error, has %d entries", loaded.Len())
+					}
+				}
+				loaded.Close()
+			}
+		})
+	}
+}
+
+func TestCompactBase(t *testing.T) {
+	// A compact snapshot is always a full snapshot. One with a base can't be
+	// applied as a delta, as its sequence numbers start at 1.
+	src := New()
+	defer src.Close()
+	src.StringToSequence("hat", true)
+	src.StringToSequence("cat", true)
+	data := compactSnapshot(t, src)
+	binary.LittleEndian.PutUint64(data[8:], 1)
+	binary.LittleEndian.PutUint64(data[16:], 2)
+	binary.LittleEndian.PutUint32(data[len(data)-4:], crc32Of(data[:len(data)-4]))
+
+	st := New()
+	defer st.Close()
+	st.StringToSequence("hat", true)
+	if err := st.ApplyDelta(bytes.NewReader(data)); err == nil || err.Error() != "compact snapshot has base 1, not 0" {
+		t.Fatalf("expected base error, got %v", err)
+	}
+	if st.Len() != 1 {
+		t.Fatalf("expected table to be unchanged, has %d entries", st.Len())
+	}
+}
+
+func compactSnapshot(t *testing.T, st *SymbolTab) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := st.WriteCompactTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkLoad(b *testing.B) {
+	st := New()
+	defer st.Close()
+	for i := range 1_000_000 {
+		st.StringToSequence("/var/log/service/"+strconv.Itoa(i%1000)+"/"+strconv.Itoa(i)+".log", true)
+	}
+
+	var text, plain, compact bytes.Buffer
+	if err := st.ExportText(&text); err != nil {
+		b.Fatal(err)
+	}
+	if _, err := st.WriteTo(&plain); err != nil {
+		b.Fatal(err)
+	}
+	if _, err := st.WriteCompactTo(&compact); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("text", func(b *testing.B) {
+		for b.Loop() {
+			loaded := New()
+			if err := loaded.ImportText(bytes.NewReader(text.Bytes())); err != nil {
+				b.Fatal(err)
+			}
+			loaded.Close()
+		}
+		b.ReportMetric(float64(text.Len()), "bytes")
+	})
+	for _, bm := range []struct {
+		name string
+		data []byte
+	}{
+		{name: "plain", data: plain.Bytes()},
+		{name: "compact", data: compact.Bytes()},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			for b.Loop() {
+				var loaded SymbolTab
+				if err := loaded.UnmarshalBinary(bm.data); err != nil {
+					b.Fatal(err)
+				}
+				loaded.Close()
+			}
+			b.ReportMetric(float64(len(bm.data)), "bytes")
+		})
+	}
+}
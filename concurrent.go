@@ -0,0 +1,231 @@
+package swisssymbols
+
+import (
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/philpearl/mmap"
+)
+
+// concurrentShardBits is the number of top hash bits used to pick a shard.
+const concurrentShardBits = 6
+
+// ConcurrentSymbolTab is a symbol table that is safe for concurrent use. Like
+// SymbolTab it maps each new string to the next sequence number, starting at
+// 1, and the sequence numbers are dense across the whole table.
+//
+// Strings are split between shards using the top bits of their hash, and each
+// shard has its own lock, so goroutines adding different strings rarely wait
+// for each other. SequenceToString never takes a lock.
+//
+// Unlike SymbolTab, a ConcurrentSymbolTab always uses anonymous memory, and
+// it can't have a journal.
+type ConcurrentSymbolTab struct {
+	shards [1 << concurrentShardBits]shard
+	seqs   seqAddresses
+	count  atomic.Uint32
+	hasher Hasher
+}
+
+// shard is one shard of a ConcurrentSymbolTab. It holds the strings whose
+// hashes have the shard's top bits.
+type shard struct {
+	mu sync.RWMutex
+	// idx holds the hash tables for the shard. Only its table directory is
+	// used. It indexes the hash returned by shardHash, as the top bits of the
+	// original hash are the same for every string in the shard.
+	idx SymbolTab
+	sb  stringBank
+	// Pad shards apart so that their locks don't share cache lines.
+	_ [64]byte
+}
+
+// NewConcurrent creates a new, empty ConcurrentSymbolTab. The only Option it
+// supports is WithHasher, and it panics if given any other. Close it when it
+// is no longer needed to release its memory.
+func NewConcurrent(opts ...Option) *ConcurrentSymbolTab {
+	var m SymbolTab
+	for _, opt := range opts {
+		opt(&m)
+	}
+	if m.readers != nil || m.shareFile || m.reuseSeqs || m.generations != 0 {
+		panic("swisssymbols: NewConcurrent only supports the WithHasher option")
+	}
+
+	c := &ConcurrentSymbolTab{hasher: m.hasher}
+	for i := range c.shards {
+		c.shards[i].idx.init()
+	}
+	c.seqs.init()
+	return c
+}
+
+// Close releases the memory used by the ConcurrentSymbolTab. It must not be
+// called while the table is in use. Calling it again does nothing.
+func (c *ConcurrentSymbolTab) Close() error {
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.idx.Close()
+		s.sb.close()
+	}
+	c.seqs.close(c.count.Load())
+	return nil
+}
+
+// Len returns the number of unique strings stored. This may include strings
+// that are still being added.
+func (c *ConcurrentSymbolTab) Len() int {
+	return int(c.count.Load())
+}
+
+// SequenceToString looks up a string by its sequence number. It does not take
+// any locks. It returns an empty string if there is no such string, or if the
+// string is still being added by another goroutine.
+func (c *ConcurrentSymbolTab) SequenceToString(seq uint32) string {
+	p := c.seqs.load(seq)
+	if p == nil {
+		return ""
+	}
+	return stringAt(p)
+}
+
+// StringToSequence looks up the string val and returns its sequence number
+// seq. If val is not in the table it adds it if addNew is true. found
+// indicates whether val was already present.
+func (c *ConcurrentSymbolTab) StringToSequence(val string, addNew bool) (seq uint32, found bool) {
+	hash := c.hasher.hash(val)
+	s := &c.shards[hash>>(hashBits-concurrentShardBits)]
+	hash = shardHash(hash)
+
+	s.mu.RLock()
+	seq = s.lookup(c, hash, val)
+	s.mu.RUnlock()
+	if seq != 0 || !addNew {
+		return seq, seq != 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Another goroutine may have added val since we looked.
+	if seq = s.lookup(c, hash, val); seq != 0 {
+		return seq, true
+	}
+
+	seq = c.count.Add(1)
+	offset := s.sb.Save(val)
+	c.seqs.store(seq, unsafe.Pointer(&s.sb.chunks[offset/stringbankSize][offset%stringbankSize]))
+
+	t := s.idx.tables[hash>>hashValue(s.idx.tableIndexShift)]
+	t.insert(entry{hash: hash, seq: seq})
+	if t.used > growthThreshold {
+		s.idx.onGrowthNeeded(t)
+	}
+	return seq, false
+}
+
+// shardHash returns the hash used within a shard. The shard's bits are shifted
+// out of the top, and we fill the bottom bits, which pick the control byte,
+// with a mix of the whole hash so they still tell strings apart.
+func shardHash(hash hashValue) hashValue {
+	return hash<<concurrentShardBits | (hash*0x9E3779B1)>>(hashBits-concurrentShardBits)
+}
+
+// lookup returns the sequence number for val, or 0 if val is not in the
+// shard. Call it with the shard lock held.
+func (s *shard) lookup(c *ConcurrentSymbolTab, hash hashValue, val string) uint32 {
+	t := s.idx.tables[hash>>hashValue(s.idx.tableIndexShift)]
+	groupHash := hash & 0x7F
+	for probe := makeProbeSeq(hash>>7, tableMask); ; probe = probe.next() {
+		group := t.groups.getGroup(probe.offset)
+		matches := group.control.findMatches(groupHash)
+		for matches != 0 {
+			index := matches.firstSet()
+			// This horrendous line gets the entry at index without doing a bounds check or nil check
+			ent := (*entry)(unsafe.Add(unsafe.Pointer(&group.entries), uintptr(index)*unsafe.Sizeof(entry{})))
+			if ent.hash == hash && c.SequenceToString(ent.seq) == val {
+				return ent.seq
+			}
+			matches = matches.clearFirstBit()
+		}
+		if group.control.findEmpty() != 0 {
+			return 0
+		}
+	}
+}
+
+// stringAt returns the string saved by a stringBank at p
+func stringAt(p unsafe.Pointer) string {
+	if l := *(*byte)(p); l&0x80 == 0 {
+		return unsafe.String((*byte)(unsafe.Add(p, 1)), int(l))
+	}
+	// A long string is followed by at least 128 bytes, so we can't read off
+	// the end of the chunk here.
+	l, llen := readLength(unsafe.Slice((*byte)(p), 10))
+	return unsafe.String((*byte)(unsafe.Add(p, llen)), l)
+}
+
+// addressSlab holds the addresses of the strings for intbanksize sequence
+// numbers.
+type addressSlab [intbanksize]atomic.Pointer[byte]
+
+// seqAddresses maps sequence numbers to the addresses of their strings. It can
+// be read without locks while it is being written. The directory of slabs is
+// big enough for every possible sequence number, so it never moves. It is
+// mapped memory, so only the parts we use take up space.
+type seqAddresses struct {
+	slabs []atomic.Pointer[addressSlab]
+}
+
+func (a *seqAddresses) init() {
+	var err error
+	a.slabs, err = mmap.Alloc[atomic.Pointer[addressSlab]]((1 << 32) / intbanksize)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// close frees the memory for the addresses. count is the highest sequence
+// number stored.
+func (a *seqAddresses) close(count uint32) {
+	if a.slabs == nil {
+		return
+	}
+	for i := range (int(count) + intbanksize - 1) / intbanksize {
+		if slab := a.slabs[i].Load(); slab != nil {
+			mmap.Free(unsafe.Slice(slab, 1))
+		}
+	}
+	mmap.Free(a.slabs)
+	a.slabs = nil
+}
+
+func (a *seqAddresses) load(seq uint32) unsafe.Pointer {
+	seq-- // externally sequence starts at 1
+	slab := a.slabs[seq/intbanksize].Load()
+	if slab == nil {
+		return nil
+	}
+	return unsafe.Pointer(slab[seq%intbanksize].Load())
+}
+
+func (a *seqAddresses) store(seq uint32, p unsafe.Pointer) {
+	seq--
+	slabs := &a.slabs[seq/intbanksize]
+	slab := slabs.Load()
+	if slab == nil {
+		// Several shards may need the same new slab at once. Only one of
+		// them wins.
+		s, err := mmap.Alloc[addressSlab](1)
+		if err != nil {
+			panic(err)
+		}
+		if slabs.CompareAndSwap(nil, &s[0]) {
+			slab = &s[0]
+		} else {
+			mmap.Free(s)
+			slab = slabs.Load()
+		}
+	}
+	slab[seq%intbanksize].Store((*byte)(p))
+}
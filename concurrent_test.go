@@ -0,0 +1,155 @@
+package swisssymbols
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConcurrent(t *testing.T) {
+	for _, hasher := range []Hasher{RuntimeHasher, StableHasher} {
+		t.Run(hasher.String(), func(t *testing.T) {
+			c := NewConcurrent(WithHasher(hasher))
+			defer c.Close()
+
+			// Each goroutine adds an overlapping range of strings, so most
+			// strings are added by several goroutines at once.
+			const (
+				workers = 8
+				count   = 200_000
+			)
+			seqs := make([][]uint32, workers)
+			var wg sync.WaitGroup
+			for w := range workers {
+				wg.Go(func() {
+					seqs[w] = make([]uint32, count)
+					for i := range count {
+						j := (i + w*count/workers) % count
+						val := strconv.Itoa(j)
+						if j%100 == 0 {
+							// Some long strings
+							val = strings.Repeat(val, 100)
+						}
+						seq, _ := c.StringToSequence(val, true)
+						seqs[w][j] = seq
+						if got := c.SequenceToString(seq); got != val {
+							t.Errorf("seq %d gives %q, expected %q", seq, got, val)
+							return
+						}
+					}
+				})
+			}
+			wg.Wait()
+
+			if c.Len() != count {
+				t.Fatalf("expected %d strings, have %d", count, c.Len())
+			}
+			seen := make([]bool, count+1)
+			for j := range count {
+				seq := seqs[0][j]
+				for w := range workers {
+					if seqs[w][j] != seq {
+						t.Fatalf("string %d has seq %d and %d", j, seq, seqs[w][j])
+					}
+				}
+				if seq == 0 || seq > count || seen[seq] {
+					t.Fatalf("string %d has bad seq %d", j, seq)
+				}
+				seen[seq] = true
+
+				val := strconv.Itoa(j)
+				if j%100 == 0 {
+					val = strings.Repeat(val, 100)
+				}
+				if got, found := c.StringToSequence(val, false); !found || got != seq {
+					t.Fatalf("looking up %q gave %d, %t, expected %d", val, got, found, seq)
+				}
+			}
+
+			if seq, found := c.StringToSequence("missing", false); found || seq != 0 {
+				t.Fatalf("found missing string as %d", seq)
+			}
+			if got := c.SequenceToString(count + 1); got != "" {
+				t.Fatalf("expected nothing past the end, got %q", got)
+			}
+		})
+	}
+}
+
+func TestConcurrentEmpty(t *testing.T) {
+	c := NewConcurrent()
+	if c.Len() != 0 {
+		t.Fatalf("expected empty table, have %d", c.Len())
+	}
+	if got := c.SequenceToString(1); got != "" {
+		t.Fatalf("expected empty string, got %q", got)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrentCloseTwice(t *testing.T) {
+	c := NewConcurrent()
+	for i := range 100_000 {
+		c.StringToSequence(strconv.Itoa(i), true)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrentOptions(t *testing.T) {
+	for _, opt := range []Option{WithReaders(), WithSharedReaders(), WithSequenceReuse(), WithGenerations(2)} {
+		func() {
+			defer func() {
+				if p := recover(); p != "swisssymbols: NewConcurrent only supports the WithHasher option" {
+					t.Fatalf("expected a panic, got %v", p)
+				}
+			}()
+			NewConcurrent(WithHasher(StableHasher), opt)
+		}()
+	}
+}
+
+func BenchmarkConcurrent(b *testing.B) {
+	const count = 1 << 20
+	vals := make([]string, count)
+	for i := range vals {
+		vals[i] = strconv.Itoa(i)
+	}
+
+	b.Run("mutex", func(b *testing.B) {
+		st := New()
+		defer st.Close()
+		var mu sync.Mutex
+		b.RunParallel(func(pb *testing.PB) {
+			var i int
+			for pb.Next() {
+				mu.Lock()
+				st.StringToSequence(vals[i%count], true)
+				mu.Unlock()
+				i += 7
+			}
+		})
+	})
+
+	b.Run("concurrent", func(b *testing.B) {
+		c := NewConcurrent()
+		defer c.Close()
+		b.RunParallel(func(pb *testing.PB) {
+			var i int
+			for pb.Next() {
+				c.StringToSequence(vals[i%count], true)
+				i += 7
+			}
+		})
+	})
+}
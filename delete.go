@@ -0,0 +1,202 @@
+package swisssymbols
+
+import "errors"
+
+// ErrHasDeletions is returned when writing a SymbolTab that has had strings
+// deleted in a format that can't leave gaps in the sequence numbers, such as
+// a snapshot, an image or a journal. Compact closes the gaps so that the
+// SymbolTab can be written again.
+var ErrHasDeletions = errors.New("swisssymbols: symbol table has deleted strings")
+
+// WithSequenceReuse makes the SymbolTab give new strings the sequence numbers
+// of deleted strings, most recently deleted first, before it uses new
+// sequence numbers. Without it sequence numbers are never reused, so a
+// sequence number held for a deleted string can't come to mean another one.
+//
+// Strings loaded by ImportText, ImportJSONL, ApplyDelta, Replay and
+// Follower.Apply always get the sequence numbers they have in their input.
+func WithSequenceReuse() Option {
+	return func(m *SymbolTab) {
+		m.reuseSeqs = true
+	}
+}
+
+// Delete removes val from the SymbolTab and returns the sequence number it
+// had. deleted is false if val was not in the SymbolTab, in which case seq is
+// 0, or if val is held (see Acquire), in which case it is not removed. Once
+// deleted the string's space is reused for new strings, so any string
+// previously returned for it by SequenceToString must no longer be used.
+//
+// Deleting a string leaves a gap in the sequence numbers. Until Compact is
+// called WriteTo, ExportSince, WriteCompactTo, ExportText, ExportJSONL,
+// WriteImage and SetJournal return ErrHasDeletions, even if the sequence
+// number has been reused.
+//
+// Delete panics with ErrReadOnly if the SymbolTab is frozen. It also panics if
+// the SymbolTab has Readers, a journal or is file-backed, as these all rely on
+// strings never being removed.
+func (m *SymbolTab) Delete(val string) (seq uint32, deleted bool) {
+	m.checkDelete()
+	hash := m.hash(val)
+	seq, found := m.stringToSequence(val, hash, true, false)
+	if !found || m.refs.get(seq) != 0 {
+		return seq, false
+	}
+	m.delete(hash, seq, m.ib.lookup(seq))
+	return seq, true
+}
+
+// DeleteSequence removes the string with sequence number seq from the
+// SymbolTab. It returns false if there is no such string, or if the string is
+// held and so not removed. See Delete.
+func (m *SymbolTab) DeleteSequence(seq uint32) bool {
+	m.checkDelete()
+	if m.refs.get(seq) != 0 {
+		return false
+	}
+	return m.deleteSequence(seq)
+}
+
+// deleteSequence removes the string with sequence number seq, if there is
+// one.
+func (m *SymbolTab) deleteSequence(seq uint32) bool {
+	if seq == 0 || int(seq) > m.count {
+		return false
+	}
+	offset := m.ib.lookup(seq)
+	if offset < 0 {
+		// Already deleted
+		return false
+	}
+	m.delete(m.hash(m.sb.Get(offset)), seq, offset)
+	return true
+}
+
+func (m *SymbolTab) checkDelete() {
+	switch {
+	case m.frozen:
+		panic(ErrReadOnly)
+	case m.readers != nil:
+		panic("swisssymbols: Delete called on a SymbolTab created WithReaders")
+	case m.journal != nil:
+		panic("swisssymbols: Delete called on a SymbolTab with a journal")
+	case m.file != nil:
+		panic("swisssymbols: Delete called on a file-backed SymbolTab")
+	}
+}
+
+// Compact renumbers the strings in the SymbolTab so that there are no gaps
+// left by deleted strings, and the SymbolTab can be written with WriteTo and
+// the like again. The strings keep their order, so the string with the lowest
+// sequence number becomes 1, the next 2, and so on, and Len sequence numbers
+// are in use afterwards.
+//
+// If renumbered is not nil it is called for each string whose sequence number
+// changes, so that the caller can update anything that holds the old number,
+// including sequence numbers from Acquire. Views of the SymbolTab must not be
+// used after Compact, and renumbered must not change the SymbolTab.
+//
+// Compact takes time in proportion to the number of sequence numbers and
+// tables. It does nothing if no strings have been deleted, and panics with
+// ErrReadOnly if the SymbolTab is frozen.
+func (m *SymbolTab) Compact(renumbered func(oldSeq, newSeq uint32)) {
+	if m.frozen {
+		panic(ErrReadOnly)
+	}
+	if m.deleted == 0 {
+		return
+	}
+	// The split's new tables would need renumbering too, and the table being
+	// split still holds all its entries.
+	m.abandonSplit()
+
+	newSeqs := make([]uint32, m.count+1)
+	var next uint32
+	for seq := uint32(1); seq <= uint32(m.count); seq++ {
+		offset := m.ib.lookup(seq)
+		if offset < 0 {
+			continue
+		}
+		next++
+		newSeqs[seq] = next
+		if next == seq {
+			continue
+		}
+		m.ib.save(next, offset)
+		m.used.move(seq, next)
+		m.refs.move(seq, next)
+		if renumbered != nil {
+			renumbered(seq, next)
+		}
+	}
+	// Strings added from now on take the sequence numbers after next, so
+	// they must start with no holders.
+	for seq := next + 1; seq <= uint32(m.count); seq++ {
+		if m.refs.get(seq) != 0 {
+			*m.refs.ref(seq) = 0
+		}
+	}
+
+	for i, t := range m.tables {
+		// A table may occupy several adjacent slots in the directory.
+		if i > 0 && m.tables[i-1] == t {
+			continue
+		}
+		t.renumber(newSeqs)
+	}
+
+	m.count = int(next)
+	m.deleted = 0
+	m.freeSeq = 0
+}
+
+// delete removes the entry for the string with sequence number seq, which is
+// at offset in the stringBank.
+func (m *SymbolTab) delete(hash hashValue, seq uint32, offset int) {
+	t := m.tables[hash>>hashValue(m.tableIndexShift)]
+	group, _ := t.remove(hash, seq)
+	if s := m.split; s != nil && t == s.src && int(group) < s.moved {
+		// The entry has already been copied to the new table.
+		s.child(hash).remove(hash, seq)
+	}
+	m.sb.release(offset)
+
+	// The intbank entry for a deleted string holds the next sequence number
+	// on the free list, as a negative number so that it can't be mistaken for
+	// an offset.
+	m.ib.save(seq, -1-int(m.freeSeq))
+	m.freeSeq = seq
+	m.deleted++
+}
+
+// pauseReuse stops new strings taking the sequence numbers of deleted strings
+// until resume is called. Loaders use it because the strings they add must
+// get the sequence numbers they have in their input, which follow on from
+// count.
+func (m *SymbolTab) pauseReuse() (resume func()) {
+	reuse := m.reuseSeqs
+	m.reuseSeqs = false
+	return func() { m.reuseSeqs = reuse }
+}
+
+// deletedUpTo returns the number of deleted sequence numbers up to upTo.
+func (m *SymbolTab) deletedUpTo(upTo uint32) int {
+	if m.deleted == 0 {
+		return 0
+	}
+	var n int
+	for seq := m.freeSeq; seq != 0; seq = uint32(-1 - m.ib.lookup(seq)) {
+		if seq <= upTo {
+			n++
+		}
+	}
+	return n
+}
+
+// reuseSeq takes a sequence number off the free list.
+func (m *SymbolTab) reuseSeq() uint32 {
+	seq := m.freeSeq
+	m.freeSeq = uint32(-1 - m.ib.lookup(seq))
+	m.deleted--
+	return seq
+}
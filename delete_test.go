@@ -0,0 +1,513 @@
+package swisssymbols
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDelete(t *testing.T) {
+	for _, h := range []Hasher{RuntimeHasher, StableHasher} {
+		t.Run(h.String(), func(t *testing.T) {
+			st := New(WithHasher(h))
+			defer st.Close()
+
+			const n = 100_000
+			for i := range n {
+				st.StringToSequence(strconv.Itoa(i), true)
+			}
+			// Delete every third string by value, and the one after it by
+			// sequence number.
+			for i := 0; i < n; i += 3 {
+				val := strconv.Itoa(i)
+				if seq, found := st.Delete(val); !found || seq != uint32(i+1) {
+					t.Fatalf("deleting %q gave %d, %t", val, seq, found)
+				}
+				if i+1 < n && !st.DeleteSequence(uint32(i+2)) {
+					t.Fatalf("deleting sequence %d failed", i+2)
+				}
+			}
+			const deleted = 2*(n/3) + 1
+			if st.Len() != n-deleted {
+				t.Fatalf("expected %d strings, have %d", n-deleted, st.Len())
+			}
+
+			for i := range n {
+				val := strconv.Itoa(i)
+				seq, found := st.StringToSequence(val, false)
+				if i%3 == 2 {
+					if !found || seq != uint32(i+1) || st.SequenceToString(seq) != val {
+						t.Fatalf("looking up %q gave %d, %t", val, seq, found)
+					}
+					continue
+				}
+				if found {
+					t.Fatalf("found deleted string %q", val)
+				}
+				if s := st.SequenceToString(uint32(i + 1)); s != "" {
+					t.Fatalf("deleted sequence %d gives %q", i+1, s)
+				}
+				if _, found := st.Delete(val); found {
+					t.Fatalf("deleted %q twice", val)
+				}
+				if st.DeleteSequence(uint32(i + 1)) {
+					t.Fatalf("deleted sequence %d twice", i+1)
+				}
+			}
+			if st.DeleteSequence(0) || st.DeleteSequence(n+1) {
+				t.Fatal("deleted a sequence number that was never used")
+			}
+
+			var count int
+			for seq, val := range st.All() {
+				if seq%3 != 0 || val != strconv.Itoa(int(seq-1)) {
+					t.Fatalf("iteration gave %d, %q", seq, val)
+				}
+				count++
+			}
+			if count != st.Len() {
+				t.Fatalf("iterated over %d strings, expected %d", count, st.Len())
+			}
+
+			// Without WithSequenceReuse, deleted strings come back with new
+			// sequence numbers.
+			for i := 0; i < 30; i += 3 {
+				val := strconv.Itoa(i)
+				if seq, found := st.StringToSequence(val, true); found || seq != uint32(n+1+i/3) {
+					t.Fatalf("re-adding %q gave %d, %t", val, seq, found)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteReuse(t *testing.T) {
+	st := New(WithSequenceReuse())
+	defer st.Close()
+
+	for i := range 10 {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	st.DeleteSequence(3)
+	st.Delete("6")
+	if st.Len() != 8 {
+		t.Fatalf("expected 8 strings, have %d", st.Len())
+	}
+
+	for _, test := range []struct {
+		val string
+		seq uint32
+	}{
+		{val: "a", seq: 7},
+		{val: "b", seq: 3},
+		{val: "c", seq: 11},
+	} {
+		if seq, found := st.StringToSequence(test.val, true); found || seq != test.seq {
+			t.Errorf("adding %q gave %d, %t, expected %d", test.val, seq, found, test.seq)
+		}
+		if s := st.SequenceToString(test.seq); s != test.val {
+			t.Errorf("sequence %d gives %q, expected %q", test.seq, s, test.val)
+		}
+	}
+	if st.Len() != 11 {
+		t.Fatalf("expected 11 strings, have %d", st.Len())
+	}
+}
+
+func TestDeleteChurn(t *testing.T) {
+	st := New(WithSequenceReuse())
+	defer st.Close()
+
+	// Keep a window of live strings while many more come and go. The
+	// tombstones they leave behind should be cleared out rather than make the
+	// table split, and the space for the strings should be reused.
+	const live = 10_000
+	const total = 1_000_000
+	seqs := make([]uint32, total)
+	for i := range total {
+		seqs[i], _ = st.StringToSequence(strconv.Itoa(total+i), true)
+		if i >= live {
+			if !st.DeleteSequence(seqs[i-live]) {
+				t.Fatalf("deleting %d failed", i-live)
+			}
+		}
+		if st.tableCount > 2 {
+			t.Fatalf("have %d tables after %d strings", st.tableCount, i)
+		}
+	}
+	// We add a string before deleting one, so there is one more sequence
+	// number than strings.
+	if st.Len() != live || st.count != live+1 {
+		t.Fatalf("expected %d strings, have %d strings and %d sequence numbers", live, st.Len(), st.count)
+	}
+	if size := st.SymbolSize(); size > 2*stringbankSize {
+		t.Errorf("strings take %d bytes", size)
+	}
+	for i := total - live; i < total; i++ {
+		val := strconv.Itoa(total + i)
+		if seq, found := st.StringToSequence(val, false); !found || seq != seqs[i] {
+			t.Fatalf("looking up %q gave %d, %t", val, seq, found)
+		}
+	}
+}
+
+func TestDeleteDuringSplit(t *testing.T) {
+	st := New()
+	defer st.Close()
+
+	// Add strings until a split is half done.
+	var n int
+	for ; st.split == nil || st.split.moved < tableSize/2; n++ {
+		st.StringToSequence(strconv.Itoa(n), true)
+	}
+	// Delete half of the strings. Some are in groups that have been moved to
+	// the new tables.
+	deletedBefore := n
+	for i := 0; i < deletedBefore; i += 2 {
+		st.Delete(strconv.Itoa(i))
+	}
+	// Add more strings until the split has finished.
+	for st.split != nil {
+		st.StringToSequence(strconv.Itoa(n), true)
+		n++
+	}
+
+	for i := range n {
+		val := strconv.Itoa(i)
+		seq, found := st.StringToSequence(val, false)
+		if deleted := i%2 == 0 && i < deletedBefore; deleted == found {
+			t.Fatalf("looking up %q gave %d, %t", val, seq, found)
+		}
+		if found && seq != uint32(i+1) {
+			t.Fatalf("looking up %q gave %d", val, seq)
+		}
+	}
+}
+
+func TestDeleteBigString(t *testing.T) {
+	st := New()
+	defer st.Close()
+
+	big := strings.Repeat("x", stringbankSize)
+	st.StringToSequence("small", true)
+	st.StringToSequence(big, true)
+	size := st.SymbolSize()
+	st.Delete(big)
+
+	// The big string's chunk is freed, and a new one allocated to add it
+	// again.
+	if seq, found := st.StringToSequence(big, true); found || seq != 3 {
+		t.Fatalf("re-adding big string gave %d, %t", seq, found)
+	}
+	if st.SymbolSize() <= size {
+		t.Fatalf("expected a new chunk")
+	}
+	if st.SequenceToString(3) != big || st.SequenceToString(1) != "small" {
+		t.Fatalf("strings are wrong")
+	}
+}
+
+func TestCompact(t *testing.T) {
+	st := New(WithGenerations(2))
+	defer st.Close()
+
+	const n = 100_000
+	for i := range n {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	held := st.Acquire(strconv.Itoa(n - 1))
+	st.AdvanceGeneration(nil)
+	st.AdvanceGeneration(nil)
+	for i := 0; i < n; i += 3 {
+		st.Delete(strconv.Itoa(i))
+	}
+	// Strings not used in this generation or the last are evicted.
+	for i := 1; i < n; i += 3 {
+		st.StringToSequence(strconv.Itoa(i), false)
+	}
+	st.AdvanceGeneration(nil)
+
+	renumbered := make(map[uint32]uint32)
+	st.Compact(func(oldSeq, newSeq uint32) {
+		if val := st.SequenceToString(oldSeq); val == "" {
+			t.Fatalf("old sequence %d gives no string", oldSeq)
+		}
+		renumbered[oldSeq] = newSeq
+	})
+
+	const live = n/3 + 1
+	if st.Len() != live {
+		t.Fatalf("expected %d strings, have %d", live, st.Len())
+	}
+	// The strings are still recorded as used in the last generation.
+	if n := st.AdvanceGeneration(nil); n != 0 {
+		t.Fatalf("evicted %d strings used in the last generation", n)
+	}
+	for i := 1; i < n; i += 3 {
+		val := strconv.Itoa(i)
+		seq, found := st.StringToSequence(val, false)
+		if exp := uint32(i/3 + 1); !found || seq != exp {
+			t.Fatalf("%q has sequence %d, %t, expected %d", val, seq, found, exp)
+		}
+		if seq != uint32(i+1) && renumbered[uint32(i+1)] != seq {
+			t.Fatalf("%q was renumbered from %d to %d, expected %d", val, i+1, renumbered[uint32(i+1)], seq)
+		}
+		if s := st.SequenceToString(seq); s != val {
+			t.Fatalf("sequence %d gives %q, expected %q", seq, s, val)
+		}
+	}
+
+	// The held string keeps its holder.
+	held = renumbered[held]
+	if held != live || st.Holders(held) != 1 {
+		t.Fatalf("held string is %d with %d holders", held, st.Holders(held))
+	}
+	st.Release(held)
+	if st.Len() != live-1 {
+		t.Fatalf("expected release to delete the held string")
+	}
+	st.Compact(nil)
+
+	// New strings follow on, with no holders.
+	if seq, found := st.StringToSequence("new", true); found || seq != live || st.Holders(seq) != 0 {
+		t.Fatalf("adding a string gave %d, %t with %d holders", seq, found, st.Holders(seq))
+	}
+
+	var buf bytes.Buffer
+	if _, err := st.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded := New()
+	defer loaded.Close()
+	if _, err := loaded.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	assertSameSymbols(t, st, loaded)
+
+	st.Freeze()
+	defer func() {
+		if r := recover(); r != ErrReadOnly {
+			t.Fatalf("expected ErrReadOnly panic, got %v", r)
+		}
+	}()
+	st.Compact(nil)
+}
+
+func TestDeleteUnsupported(t *testing.T) {
+	expectPanic := func(t *testing.T, st *SymbolTab) {
+		t.Helper()
+		defer func() {
+			t.Helper()
+			if p := recover(); p == nil {
+				t.Fatalf("expected a panic")
+			}
+		}()
+		st.Delete("a")
+	}
+
+	t.Run("frozen", func(t *testing.T) {
+		st := New()
+		defer st.Close()
+		st.StringToSequence("a", true)
+		st.Freeze()
+		defer func() {
+			if p := recover(); p != ErrReadOnly {
+				t.Fatalf("expected ErrReadOnly panic, got %v", p)
+			}
+		}()
+		st.Delete("a")
+	})
+
+	t.Run("readers", func(t *testing.T) {
+		st := New(WithReaders())
+		defer st.Close()
+		expectPanic(t, st)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		st, err := OpenFile(filepath.Join(t.TempDir(), "symbols"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer st.Close()
+		expectPanic(t, st)
+	})
+
+	t.Run("exports", func(t *testing.T) {
+		st := New()
+		defer st.Close()
+		st.StringToSequence("a", true)
+		st.StringToSequence("b", true)
+		st.Delete("a")
+
+		for name, export := range map[string]func() error{
+			"WriteTo":        func() error { _, err := st.WriteTo(io.Discard); return err },
+			"WriteCompactTo": func() error { _, err := st.WriteCompactTo(io.Discard); return err },
+			"ExportText":     func() error { return st.ExportText(io.Discard) },
+			"ExportJSONL":    func() error { return st.ExportJSONL(io.Discard) },
+			"WriteImage":     func() error { _, err := st.WriteImage(io.Discard); return err },
+		} {
+			if err := export(); !errors.Is(err, ErrHasDeletions) {
+				t.Errorf("%s: expected ErrHasDeletions, got %v", name, err)
+			}
+		}
+	})
+
+	t.Run("journal", func(t *testing.T) {
+		j, err := OpenJournal(filepath.Join(t.TempDir(), "journal"), SyncNever)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer j.Close()
+
+		st := New()
+		defer st.Close()
+		st.StringToSequence("a", true)
+		st.StringToSequence("b", true)
+		st.Delete("a")
+		if err := st.SetJournal(j); !errors.Is(err, ErrHasDeletions) {
+			t.Errorf("expected ErrHasDeletions, got %v", err)
+		}
+
+		// A journal can't record reused sequence numbers, so it is refused
+		// even before anything is deleted.
+		reuse := New(WithSequenceReuse())
+		defer reuse.Close()
+		reuse.StringToSequence("a", true)
+		if err := reuse.SetJournal(j); err == nil {
+			t.Errorf("expected an error attaching a journal to a SymbolTab with sequence reuse")
+		}
+	})
+}
+
+func TestDeleteReuseLoaders(t *testing.T) {
+	// Strings a loader adds get the sequence numbers from its input, even
+	// if there are deleted sequence numbers waiting to be reused.
+	src := New(WithReaders())
+	defer src.Close()
+	for i := range 10 {
+		src.StringToSequence(strconv.Itoa(i), true)
+	}
+
+	// loadsAfterDelete sets up a SymbolTab holding the first 5 strings with
+	// the second deleted, then loads the rest.
+	loadsAfterDelete := func(load func(st *SymbolTab) error) func(t *testing.T) *SymbolTab {
+		return func(t *testing.T) *SymbolTab {
+			st := New(WithSequenceReuse())
+			for i := range 5 {
+				st.StringToSequence(strconv.Itoa(i), true)
+			}
+			st.DeleteSequence(2)
+			if err := load(st); err != nil {
+				t.Fatal(err)
+			}
+			return st
+		}
+	}
+
+	var text, jsonl, delta, compact bytes.Buffer
+	for seq := uint32(6); seq <= 10; seq++ {
+		fmt.Fprintf(&text, "%d\t%s\n", seq, src.SequenceToString(seq))
+		fmt.Fprintf(&jsonl, "{\"seq\":%d,\"s\":%q}\n", seq, src.SequenceToString(seq))
+	}
+	if _, err := src.ExportSince(&delta, 5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.WriteCompactTo(&compact); err != nil {
+		t.Fatal(err)
+	}
+
+	// A journal that carries on from sequence 5
+	journal := []byte(journalMagic)
+	journal = binary.LittleEndian.AppendUint32(journal, journalVersion)
+	for seq := uint32(6); seq <= 10; seq++ {
+		val := src.SequenceToString(seq)
+		hdr := binary.LittleEndian.AppendUint32(nil, seq)
+		hdr = binary.LittleEndian.AppendUint32(hdr, uint32(len(val)))
+		journal = binary.LittleEndian.AppendUint32(journal, crc32.Checksum(hdr, crcTable))
+		journal = append(journal, hdr...)
+		journal = binary.LittleEndian.AppendUint32(journal, crc32.Checksum([]byte(val), crcTable))
+		journal = append(journal, val...)
+	}
+
+	tests := []struct {
+		name string
+		load func(t *testing.T) *SymbolTab
+		// deleted is the sequence number deleted before loading, if any.
+		deleted uint32
+	}{
+		{
+			name:    "ImportText",
+			load:    loadsAfterDelete(func(st *SymbolTab) error { return st.ImportText(&text) }),
+			deleted: 2,
+		},
+		{
+			name:    "ImportJSONL",
+			load:    loadsAfterDelete(func(st *SymbolTab) error { return st.ImportJSONL(&jsonl) }),
+			deleted: 2,
+		},
+		{
+			name:    "ApplyDelta",
+			load:    loadsAfterDelete(func(st *SymbolTab) error { return st.ApplyDelta(&delta) }),
+			deleted: 2,
+		},
+		{
+			name:    "Replay",
+			load:    loadsAfterDelete(func(st *SymbolTab) error { return st.Replay(bytes.NewReader(journal)) }),
+			deleted: 2,
+		},
+		{
+			// A compact snapshot can only be applied to an empty
+			// SymbolTab, so there's nothing to delete first.
+			name: "compact",
+			load: func(t *testing.T) *SymbolTab {
+				st := New(WithSequenceReuse())
+				if err := st.ApplyDelta(&compact); err != nil {
+					t.Fatal(err)
+				}
+				return st
+			},
+		},
+		{
+			// A Follower has Readers, so strings can't be deleted from it.
+			name: "Follower",
+			load: func(t *testing.T) *SymbolTab {
+				st := New(WithReaders(), WithSequenceReuse())
+				if err := NewFollower(st).Apply(shipLog(t, src, 0)); err != nil {
+					t.Fatal(err)
+				}
+				return st
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			st := test.load(t)
+			defer st.Close()
+			for seq := uint32(1); seq <= 10; seq++ {
+				expected := src.SequenceToString(seq)
+				if seq == test.deleted {
+					expected = ""
+				}
+				if got := st.SequenceToString(seq); got != expected {
+					t.Errorf("sequence %d gives %q, expected %q", seq, got, expected)
+				}
+			}
+
+			// Reuse carries on after loading
+			if test.deleted != 0 {
+				if seq, _ := st.StringToSequence("new", true); seq != test.deleted {
+					t.Errorf("expected new string to reuse %d, got %d", test.deleted, seq)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package swisssymbols
+
+import (
+	"context"
+	"iter"
+	"sync"
+	"sync/atomic"
+)
+
+// Subscribe returns an iterator over the strings added to the SymbolTab after
+// sequence number after, with their sequence numbers, in sequence order. Once
+// it has caught up it waits for new strings, so it only stops when ctx is done
+// or the loop ends. To resume a subscription, pass the last sequence number
+// seen.
+//
+// The SymbolTab must have been created with WithReaders. Each subscription
+// reads the SymbolTab through its own Reader, so a slow subscriber never
+// holds up the goroutine adding strings, and there is no limit to how far it
+// can fall behind. Subscribe may be called from any goroutine.
+func (m *SymbolTab) Subscribe(ctx context.Context, after uint32) iter.Seq2[uint32, string] {
+	if m.readers == nil {
+		panic("swisssymbols: Subscribe called on a SymbolTab created without WithReaders")
+	}
+	return func(yield func(uint32, string) bool) {
+		r := m.NewReader()
+		defer r.Close()
+		for seq := after + 1; ; seq++ {
+			if uint32(r.Len()) < seq && !r.rs.feed.wait(ctx, r.rs, seq) {
+				return
+			}
+			if !yield(seq, r.SequenceToString(seq)) {
+				return
+			}
+		}
+	}
+}
+
+// feed wakes subscribers when strings are added.
+type feed struct {
+	// waiting is set if any subscriber may be waiting for wake to close.
+	waiting atomic.Bool
+	mu      sync.Mutex
+	wake    chan struct{}
+}
+
+// notify wakes any waiting subscribers. Call it after the count is updated.
+func (f *feed) notify() {
+	if !f.waiting.Load() {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.wake != nil {
+		close(f.wake)
+		f.wake = nil
+	}
+	f.waiting.Store(false)
+}
+
+// wait waits until there are at least seq strings. It returns false if ctx is
+// done first.
+func (f *feed) wait(ctx context.Context, rs *readerState, seq uint32) bool {
+	for {
+		f.mu.Lock()
+		if f.wake == nil {
+			f.wake = make(chan struct{})
+		}
+		wake := f.wake
+		f.waiting.Store(true)
+		f.mu.Unlock()
+
+		// We check the count after setting waiting, and the writer checks
+		// waiting after setting the count, so one of us will see the other.
+		if rs.count.Load() >= seq {
+			return true
+		}
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package swisssymbols
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	st := New(WithReaders())
+	defer st.Close()
+	for i := range 1000 {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+
+	const count = 100_000
+	var wg sync.WaitGroup
+	for _, after := range []uint32{0, 700, 5000} {
+		wg.Go(func() {
+			expect := after + 1
+			for seq, val := range st.Subscribe(context.Background(), after) {
+				if seq != expect || val != strconv.Itoa(int(seq)-1) {
+					t.Errorf("expected seq %d, got %d %q", expect, seq, val)
+					return
+				}
+				if seq == count {
+					return
+				}
+				expect++
+			}
+		})
+	}
+	for i := 1000; i < count; i++ {
+		st.StringToSequence(strconv.Itoa(i), true)
+		if i%1000 == 0 {
+			// Give the subscribers a chance to catch up and wait.
+			time.Sleep(time.Millisecond)
+		}
+	}
+	wg.Wait()
+}
+
+func TestSubscribeSlow(t *testing.T) {
+	st := New(WithReaders())
+	defer st.Close()
+
+	// The subscriber doesn't read anything until the writer is finished, but
+	// the writer doesn't wait for it.
+	unblock := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		var count int
+		for seq := range st.Subscribe(context.Background(), 0) {
+			<-unblock
+			count++
+			if seq == 10_000 {
+				break
+			}
+		}
+		if count != 10_000 {
+			t.Errorf("expected 10000 strings, got %d", count)
+		}
+	})
+	for i := range 10_000 {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	close(unblock)
+	wg.Wait()
+}
+
+func TestSubscribeCancel(t *testing.T) {
+	st := New(WithReaders())
+	defer st.Close()
+	st.StringToSequence("a", true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	var seen []string
+	go func() {
+		defer close(done)
+		for _, val := range st.Subscribe(ctx, 0) {
+			seen = append(seen, val)
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("subscription did not stop when cancelled")
+	}
+	if len(seen) != 1 || seen[0] != "a" {
+		t.Fatalf("expected to see a, saw %q", seen)
+	}
+}
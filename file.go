@@ -0,0 +1,540 @@
+package swisssymbols
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+
+	"github.com/philpearl/mmap"
+)
+
+// A file-backed SymbolTab is a header page followed by a sequence of regions.
+// Each region is a regionHeader followed by a table, an intbank slab or a
+// string chunk. The whole file is mapped into one contiguous range of memory
+// with MAP_SHARED, and the SymbolTab uses the structures in the file directly.
+//
+// The directory is not stored. It is rebuilt from the localDepth and index of
+// each table when the file is opened.
+//
+// The structures are stored as they are laid out in memory, with the
+// machine's byte order and int size, so a file can only be opened on a
+// machine that matches the one that created it. The header records both.
+const (
+	fileMagic      = "swsymtab"
+	fileVersion    = 1
+	fileHeaderSize = 4096
+
+	// fileByteOrder is stored in the header in the machine's byte order, so
+	// a machine with the other byte order reads a different value.
+	fileByteOrder = 0x01020304
+	// fileIntSize is the size in bytes of the ints in the file.
+	fileIntSize = int(unsafe.Sizeof(int(0)))
+
+	// We grow the file by at least this much at a time.
+	fileGrowth = 1 << 22
+
+	regionHeaderSize = int(unsafe.Sizeof(regionHeader{}))
+)
+
+type fileHeader struct {
+	magic   [8]byte
+	version uint32
+	// hasher is the Hasher used for the tables
+	hasher uint32
+	// count is the number of strings as of the last Sync
+	count uint64
+	// end is the offset of the end of the last region as of the last Sync
+	end uint64
+	// clean is set when the file is closed, and cleared when it is opened.
+	clean uint32
+	// shared is set if the file was last opened WithSharedReaders. Only then
+	// are live and liveEnd kept up to date.
+	shared uint32
+	// live is the number of strings, updated as each string is added.
+	// liveEnd is the end of the regions those strings need. Shared readers
+	// load live, then liveEnd. See SharedReader.
+	live    uint64
+	liveEnd uint64
+	// byteOrder is fileByteOrder and intSize is fileIntSize on the machine
+	// that created the file.
+	byteOrder uint32
+	intSize   uint32
+}
+
+// check checks that the file is a swisssymbols file that this machine can
+// read.
+func (hdr *fileHeader) check() error {
+	if string(hdr.magic[:]) != fileMagic {
+		return errors.New("not a swisssymbols file")
+	}
+	if hdr.version != fileVersion {
+		return fmt.Errorf("unsupported file version %d", hdr.version)
+	}
+	if hdr.byteOrder != fileByteOrder {
+		return errors.New("file was created on a machine with a different byte order")
+	}
+	if hdr.intSize != uint32(fileIntSize) {
+		return fmt.Errorf("file was created on a machine with %d-byte ints, not %d", hdr.intSize, fileIntSize)
+	}
+	return nil
+}
+
+const (
+	regionTable = iota + 1
+	regionFreeTable
+	regionSlab
+	regionChunk
+	// regionNewTable is a table that is being filled by a split. See
+	// split.go.
+	regionNewTable
+)
+
+type regionHeader struct {
+	kind uint32
+	_    uint32
+	// size of the region, not including the header
+	size uint64
+}
+
+var (
+	// ErrLocked is returned by OpenFile if another SymbolTab has the file
+	// open.
+	ErrLocked = errors.New("swisssymbols: file is locked by another SymbolTab")
+)
+
+// mappedFile is an allocator that allocates from a mapped file.
+type mappedFile struct {
+	f    *os.File
+	base uintptr
+	// mapped is the number of bytes of the file that are mapped. It is also
+	// the size of the file.
+	mapped     int
+	hdr        *fileHeader
+	freeTables []*table
+	// end is the offset of the end of the last region. The header's end is
+	// only updated by sync, once the regions it covers are on disk, because
+	// the OS may write the header page back at any time.
+	end int
+
+	// readOnly is set if the file is mapped for a SharedReader.
+	readOnly bool
+	// shared is set if SharedReaders may be reading the file. Tables are
+	// then never reused while the file is open, as a reader may be looking
+	// at them. Instead retired tables are marked free when the file is
+	// closed.
+	shared  bool
+	retired []*table
+}
+
+// OpenFile opens a SymbolTab stored in the file at path, creating the file if
+// it does not exist. The tables, the sequence number index and the strings all
+// live in the file, which is mapped into memory, so opening a file is fast
+// however big the table is. Adding strings grows the file.
+//
+// Call Sync to flush changes to disk, and Close to flush them and close the
+// file. If the process stops without calling Close, strings added since the
+// last Sync are lost when the file is next opened.
+//
+// Only one SymbolTab can have a file open at once, but other processes can read
+// it at the same time with OpenSharedReader if it is opened WithSharedReaders.
+//
+// File-backed tables use StableHasher. Passing a different Hasher is an error.
+//
+// File-backed tables are only supported on 64-bit platforms. The file holds
+// the tables as they are laid out in memory, so it can only be opened on a
+// machine with the same byte order and int size as the one that created it.
+func OpenFile(path string, opts ...Option) (*SymbolTab, error) {
+	m := &SymbolTab{
+		hasher: StableHasher,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.hasher != StableHasher {
+		return nil, fmt.Errorf("file-backed tables must use StableHasher, not %s", m.hasher)
+	}
+	m.setLookupFlags()
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, ErrLocked
+		}
+		return nil, fmt.Errorf("locking %s: %w", path, err)
+	}
+
+	mf, created, err := mapFile(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	m.mem = mf
+	m.file = mf
+	m.ib.mem = mf
+	m.sb.mem = mf
+
+	// The file isn't safe to share until we've restored it.
+	atomic.StoreUint32(&mf.hdr.shared, 0)
+
+	if created {
+		copy(mf.hdr.magic[:], fileMagic)
+		mf.hdr.version = fileVersion
+		mf.hdr.hasher = uint32(m.hasher)
+		mf.hdr.byteOrder = fileByteOrder
+		mf.hdr.intSize = uint32(fileIntSize)
+		mf.hdr.end = fileHeaderSize
+		mf.end = fileHeaderSize
+		m.init()
+	} else if err := m.restore(); err != nil {
+		if m.tables != nil {
+			mmap.Free(m.tables)
+		}
+		mf.unmap()
+		f.Close()
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	if m.readers != nil {
+		m.readers.publish(m)
+	}
+	if m.shareFile {
+		mf.share(m.count)
+	}
+
+	mf.hdr.clean = 0
+	if err := mf.msync(0, fileHeaderSize); err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// Sync flushes a file-backed SymbolTab to disk. It does nothing for other
+// SymbolTabs.
+func (m *SymbolTab) Sync() error {
+	if m.file == nil {
+		return nil
+	}
+	return m.file.sync(m.count)
+}
+
+// restore sets up the SymbolTab from the regions in the file.
+func (m *SymbolTab) restore() error {
+	mf := m.file
+	hdr := mf.hdr
+	if err := hdr.check(); err != nil {
+		return err
+	}
+	if hdr.hasher != uint32(m.hasher) {
+		return fmt.Errorf("file uses %s, not %s", Hasher(hdr.hasher), m.hasher)
+	}
+	if hdr.end < fileHeaderSize || hdr.end > uint64(mf.mapped) {
+		return fmt.Errorf("file is corrupt: data ends at %d but file is %d bytes", hdr.end, mf.mapped)
+	}
+	mf.end = int(hdr.end)
+
+	var tables []*table
+	for off := uint64(fileHeaderSize); off < hdr.end; {
+		rh := (*regionHeader)(mf.ptr(int(off)))
+		p := mf.ptr(int(off) + regionHeaderSize)
+		if off+uint64(regionHeaderSize)+rh.size > hdr.end {
+			return fmt.Errorf("file is corrupt: region at %d overruns the data", off)
+		}
+		switch rh.kind {
+		case regionTable:
+			tables = append(tables, (*table)(p))
+		case regionFreeTable, regionNewTable:
+			rh.kind = regionFreeTable
+			mf.freeTables = append(mf.freeTables, (*table)(p))
+		case regionSlab:
+			m.ib.slabs = append(m.ib.slabs, unsafe.Slice((*int)(p), intbanksize))
+		case regionChunk:
+			m.sb.restore(unsafe.Slice((*byte)(p), rh.size))
+		default:
+			return fmt.Errorf("file is corrupt: unknown region type %d at %d", rh.kind, off)
+		}
+		off += uint64(regionHeaderSize) + rh.size
+	}
+
+	m.count = int(hdr.count)
+	if m.count > len(m.ib.slabs)*intbanksize {
+		return fmt.Errorf("file is corrupt: count %d is larger than the sequence index", m.count)
+	}
+
+	if hdr.clean == 0 {
+		// The file was not closed cleanly, so the tables may contain entries
+		// added after the last Sync. The sequence number index and the
+		// strings are only ever appended to, so everything up to the count
+		// saved by the last Sync is still good. We rebuild the tables from
+		// that.
+		for _, t := range tables {
+			mf.freeTable(t)
+		}
+		m.init()
+		for seq := uint32(1); seq <= uint32(m.count); seq++ {
+			m.index(seq)
+		}
+		return nil
+	}
+
+	if len(tables) == 0 {
+		return errors.New("file is corrupt: it contains no tables")
+	}
+	var depth uint16
+	for _, t := range tables {
+		depth = max(depth, t.localDepth)
+	}
+	var err error
+	m.tables, err = mmap.Alloc[*table](1 << depth)
+	if err != nil {
+		return err
+	}
+	m.tableIndexDepth = depth
+	m.tableIndexShift = hashBits - depth
+	m.tableCount = len(tables)
+	for _, t := range tables {
+		if int(t.index) >= 1<<t.localDepth {
+			return fmt.Errorf("file is corrupt: table index %d is invalid at depth %d", t.index, t.localDepth)
+		}
+		m.insertTable(t)
+	}
+	for _, t := range m.tables {
+		if t == nil {
+			return errors.New("file is corrupt: tables do not cover all hash values")
+		}
+	}
+
+	return nil
+}
+
+// index adds an entry for an existing sequence number to the tables.
+func (m *SymbolTab) index(seq uint32) {
+	if m.split != nil {
+		m.abandonSplit()
+	}
+	hash := m.hash(m.SequenceToString(seq))
+	t := m.tables[hash>>hashValue(m.tableIndexShift)]
+	t.insert(entry{hash: hash, seq: seq})
+	if t.used > growthThreshold {
+		m.onGrowthNeeded(t)
+	}
+}
+
+// mapFile maps f into memory. created is true if the file was empty.
+func mapFile(f *os.File) (mf *mappedFile, created bool, err error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+	size := int(fi.Size())
+	created = size == 0
+	if !created && size < fileHeaderSize {
+		return nil, false, errors.New("file is too small to be a swisssymbols file")
+	}
+	if rounded := roundUp(max(size, fileGrowth), os.Getpagesize()); rounded != size {
+		if err := f.Truncate(int64(rounded)); err != nil {
+			return nil, false, err
+		}
+		size = rounded
+	}
+
+	// We reserve address space for the largest file we support, then map the
+	// file at the start of it. As the file grows we map the new parts
+	// directly after the old, so the memory never moves.
+	mf = &mappedFile{f: f}
+	if err := mf.reserve(size); err != nil {
+		return nil, false, err
+	}
+	return mf, created, nil
+}
+
+// reserve reserves address space for the file and maps the first size bytes.
+func (mf *mappedFile) reserve(size int) error {
+	base, err := sysMmap(
+		0,
+		maxFileSize,
+		syscall.PROT_NONE,
+		syscall.MAP_PRIVATE|syscall.MAP_ANON|syscall.MAP_NORESERVE,
+		^uintptr(0),
+		0,
+	)
+	if err != nil {
+		return fmt.Errorf("reserving address space: %w", err)
+	}
+	mf.base = base
+	if err := mf.mapRange(0, size); err != nil {
+		mf.unmap()
+		return err
+	}
+	mf.mapped = size
+	mf.hdr = (*fileHeader)(mf.ptr(0))
+	return nil
+}
+
+// mapRange maps bytes [from, to) of the file at the same offsets in our
+// address range.
+func (mf *mappedFile) mapRange(from, to int) error {
+	prot := syscall.PROT_READ | syscall.PROT_WRITE
+	if mf.readOnly {
+		prot = syscall.PROT_READ
+	}
+	if _, err := sysMmap(
+		mf.base+uintptr(from),
+		uintptr(to-from),
+		prot,
+		syscall.MAP_SHARED|syscall.MAP_FIXED,
+		mf.f.Fd(),
+		uintptr(from),
+	); err != nil {
+		return fmt.Errorf("mapping file: %w", err)
+	}
+	return nil
+}
+
+func (mf *mappedFile) unmap() {
+	syscall.Syscall(syscall.SYS_MUNMAP, mf.base, maxFileSize, 0)
+}
+
+// ptr returns a pointer to offset off in the file.
+func (mf *mappedFile) ptr(off int) unsafe.Pointer {
+	// This is a trick to convert the uintptr to an unsafe.Pointer without
+	// upsetting vet. The memory isn't managed by Go so this is safe.
+	p := mf.base + uintptr(off)
+	return *(*unsafe.Pointer)(unsafe.Pointer(&p))
+}
+
+func (mf *mappedFile) msync(off, size int) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, mf.base+uintptr(off), uintptr(size), syscall.MS_SYNC)
+	if errno != 0 {
+		return fmt.Errorf("syncing file: %w", errno)
+	}
+	return nil
+}
+
+// sync flushes the file to disk, then records count and the end of the
+// regions in the header. msync doesn't flush pages in any particular order, so
+// the header must not cover anything until it is on disk.
+func (mf *mappedFile) sync(count int) error {
+	if err := mf.msync(0, mf.mapped); err != nil {
+		return err
+	}
+	mf.hdr.count = uint64(count)
+	mf.hdr.end = uint64(mf.end)
+	return mf.msync(0, fileHeaderSize)
+}
+
+func (mf *mappedFile) close(count int) error {
+	for _, t := range mf.retired {
+		mf.region(unsafe.Pointer(t)).kind = regionFreeTable
+	}
+	err := mf.sync(count)
+	if err == nil {
+		mf.hdr.clean = 1
+		err = mf.msync(0, fileHeaderSize)
+	}
+	end := roundUp(mf.end, os.Getpagesize())
+	mf.unmap()
+	if err == nil {
+		// Drop the unused space at the end of the file
+		err = mf.f.Truncate(int64(end))
+	}
+	if cerr := mf.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// alloc allocates a region of the given kind and size at the end of the file.
+func (mf *mappedFile) alloc(kind uint32, size int) unsafe.Pointer {
+	size = roundUp(size, 8)
+	off := mf.end
+	end := off + regionHeaderSize + size
+	if end > mf.mapped {
+		mf.grow(end)
+	}
+	rh := (*regionHeader)(mf.ptr(off))
+	rh.kind = kind
+	rh.size = uint64(size)
+	mf.end = end
+	return mf.ptr(off + regionHeaderSize)
+}
+
+// grow grows the file so it is at least size bytes.
+func (mf *mappedFile) grow(size int) {
+	newSize := roundUp(max(size, mf.mapped+max(mf.mapped/4, fileGrowth)), fileGrowth)
+	if newSize > maxFileSize {
+		panic("swisssymbols: file has reached maximum size")
+	}
+	if err := mf.f.Truncate(int64(newSize)); err != nil {
+		panic(fmt.Sprintf("swisssymbols: growing file: %v", err))
+	}
+	if err := mf.mapRange(mf.mapped, newSize); err != nil {
+		panic(fmt.Sprintf("swisssymbols: growing file: %v", err))
+	}
+	mf.mapped = newSize
+}
+
+func (mf *mappedFile) region(p unsafe.Pointer) *regionHeader {
+	return (*regionHeader)(unsafe.Add(p, -regionHeaderSize))
+}
+
+func (mf *mappedFile) allocTable() *table {
+	var t *table
+	if l := len(mf.freeTables); l > 0 {
+		t = mf.freeTables[l-1]
+		mf.freeTables = mf.freeTables[:l-1]
+		mf.region(unsafe.Pointer(t)).kind = regionTable
+	} else {
+		t = (*table)(mf.alloc(regionTable, int(unsafe.Sizeof(table{}))))
+	}
+	return t
+}
+
+func (mf *mappedFile) freeTable(t *table) {
+	if mf.shared {
+		mf.retired = append(mf.retired, t)
+		return
+	}
+	mf.region(unsafe.Pointer(t)).kind = regionFreeTable
+	mf.freeTables = append(mf.freeTables, t)
+}
+
+func (mf *mappedFile) allocSlab() []int {
+	return unsafe.Slice((*int)(mf.alloc(regionSlab, intbanksize*int(unsafe.Sizeof(int(0))))), intbanksize)
+}
+
+// Slabs and chunks are never freed from the file. Their memory is released
+// when the file is closed.
+func (mf *mappedFile) freeSlab(s []int)   {}
+func (mf *mappedFile) freeChunk(c []byte) {}
+
+func (mf *mappedFile) allocChunk(size int) []byte {
+	return unsafe.Slice((*byte)(mf.alloc(regionChunk, size)), size)
+}
+
+// share lets SharedReaders read the file. count is the number of strings.
+func (mf *mappedFile) share(count int) {
+	mf.shared = true
+	// Free tables might be in use by readers that opened the file before it
+	// was last closed, so we don't reuse them.
+	mf.freeTables = nil
+	mf.publish(count)
+	atomic.StoreUint32(&mf.hdr.shared, 1)
+}
+
+// publish makes count strings visible to SharedReaders.
+func (mf *mappedFile) publish(count int) {
+	atomic.StoreUint64(&mf.hdr.liveEnd, uint64(mf.end))
+	atomic.StoreUint64(&mf.hdr.live, uint64(count))
+}
+
+func roundUp(v, to int) int {
+	return (v + to - 1) / to * to
+}
@@ -0,0 +1,20 @@
+//go:build amd64 || arm64 || loong64 || mips64 || mips64le || ppc64 || ppc64le || riscv64 || s390x
+
+package swisssymbols
+
+import "syscall"
+
+// maxFileSize is the address space we reserve when we open a file, so that
+// the mapping never has to move as the file grows. The file can't grow beyond
+// this.
+const maxFileSize = 1 << 40
+
+// sysMmap makes the mmap system call. We make it directly because syscall.Mmap
+// doesn't let us choose the address.
+func sysMmap(addr, length uintptr, prot, flags int, fd, offset uintptr) (uintptr, error) {
+	p, _, errno := syscall.Syscall6(syscall.SYS_MMAP, addr, length, uintptr(prot), uintptr(flags), fd, offset)
+	if errno != 0 {
+		return 0, errno
+	}
+	return p, nil
+}
@@ -0,0 +1,14 @@
+//go:build !amd64 && !arm64 && !loong64 && !mips64 && !mips64le && !ppc64 && !ppc64le && !riscv64 && !s390x
+
+package swisssymbols
+
+import "errors"
+
+// File-backed SymbolTabs are only supported on 64-bit platforms. 32-bit
+// platforms don't have the address space to reserve for a file, and each
+// passes the offset to mmap in its own way.
+const maxFileSize = 0
+
+func sysMmap(addr, length uintptr, prot, flags int, fd, offset uintptr) (uintptr, error) {
+	return 0, errors.New("file-backed SymbolTabs are only supported on 64-bit platforms")
+}
@@ -0,0 +1,287 @@
+package swisssymbols
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestOpenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "symbols")
+
+	st, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Enough strings to split tables and grow the file several times, plus
+	// one string too big for a normal chunk.
+	const n = 200_000
+	for i := range n {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	big := strings.Repeat("big", stringbankSize)
+	st.StringToSequence(big, true)
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err = OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	if st.Len() != n+1 {
+		t.Fatalf("expected %d strings, got %d", n+1, st.Len())
+	}
+	for i := range n {
+		val := strconv.Itoa(i)
+		seq, found := st.StringToSequence(val, false)
+		if !found || seq != uint32(i+1) {
+			t.Fatalf("expected seq %d for %s, got %d (found=%t)", i+1, val, seq, found)
+		}
+		if actual := st.SequenceToString(seq); actual != val {
+			t.Fatalf("expected %s for seq %d, got %s", val, seq, actual)
+		}
+	}
+	if seq, found := st.StringToSequence(big, false); !found || seq != n+1 {
+		t.Fatalf("expected to find big string at %d, got %d (found=%t)", n+1, seq, found)
+	}
+
+	// We can carry on adding strings
+	seq, found := st.StringToSequence("new", true)
+	if found || seq != n+2 {
+		t.Fatalf("expected new string to get seq %d, got %d (found=%t)", n+2, seq, found)
+	}
+}
+
+func TestOpenFileReadFrom(t *testing.T) {
+	src := New()
+	defer src.Close()
+	for i := range 1000 {
+		src.StringToSequence(strconv.Itoa(i), true)
+	}
+	var snapshot bytes.Buffer
+	if _, err := src.WriteTo(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "symbols")
+	st, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed load leaves the SymbolTab empty, but still backed by the
+	// file.
+	corrupt := bytes.Clone(snapshot.Bytes())
+	corrupt[len(corrupt)-10] ^= 0xFF
+	if _, err := st.ReadFrom(bytes.NewReader(corrupt)); err == nil {
+		t.Fatal("expected an error loading a corrupt snapshot")
+	}
+	if st.Len() != 0 {
+		t.Fatalf("expected an empty SymbolTab after a failed load, have %d strings", st.Len())
+	}
+	if _, err := st.ReadFrom(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	st.StringToSequence("hello", true)
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err = OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if st.Len() != 1001 {
+		t.Fatalf("expected 1001 strings in the reopened file, have %d", st.Len())
+	}
+	for i := range 1000 {
+		if s := st.SequenceToString(uint32(i + 1)); s != strconv.Itoa(i) {
+			t.Fatalf("sequence %d gives %q", i+1, s)
+		}
+	}
+	if seq, found := st.StringToSequence("hello", false); !found || seq != 1001 {
+		t.Fatalf("expected hello at 1001, got %d, %t", seq, found)
+	}
+}
+
+func TestOpenFileLocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "symbols")
+	st, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	if _, err := OpenFile(path); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+}
+
+func TestOpenFileNotClean(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "symbols")
+	st, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 50_000 {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	if err := st.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 50_000 {
+		st.StringToSequence("lost"+strconv.Itoa(i), true)
+	}
+
+	// Simulate a crash by dropping the file without closing the SymbolTab
+	st.file.unmap()
+	st.file.f.Close()
+
+	st, err = OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	if st.Len() != 50_000 {
+		t.Fatalf("expected 50000 strings, got %d", st.Len())
+	}
+	for i := range 50_000 {
+		val := strconv.Itoa(i)
+		if seq, found := st.StringToSequence(val, false); !found || seq != uint32(i+1) {
+			t.Fatalf("expected seq %d for %s, got %d (found=%t)", i+1, val, seq, found)
+		}
+		if _, found := st.StringToSequence("lost"+val, false); found {
+			t.Fatalf("did not expect to find lost%s", val)
+		}
+	}
+	if seq, _ := st.StringToSequence("lost0", true); seq != 50_001 {
+		t.Fatalf("expected seq 50001, got %d", seq)
+	}
+}
+
+func TestOpenFileLostRegions(t *testing.T) {
+	// The OS can write the header page back to the file at any time, so it
+	// may reach the disk without the regions added since the last Sync.
+	path := filepath.Join(t.TempDir(), "symbols")
+	st, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 50_000 {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	if err := st.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	synced := st.file.end
+	for i := range 50_000 {
+		st.StringToSequence("lost"+strconv.Itoa(i), true)
+	}
+	if st.file.end == synced {
+		t.Fatal("expected new regions after the Sync")
+	}
+
+	// Simulate a crash where the header reached the disk but none of the
+	// regions after it did.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.file.unmap()
+	st.file.f.Close()
+	clear(data[synced:])
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err = OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if st.Len() != 50_000 {
+		t.Fatalf("expected 50000 strings, got %d", st.Len())
+	}
+	if seq, _ := st.StringToSequence("lost0", true); seq != 50_001 {
+		t.Fatalf("expected seq 50001, got %d", seq)
+	}
+}
+
+func TestOpenFileHasher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "symbols")
+	if _, err := OpenFile(path, WithHasher(RuntimeHasher)); err == nil {
+		t.Fatal("expected an error opening a file with RuntimeHasher")
+	}
+}
+
+func TestOpenFileNotSymbols(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "symbols")
+	if err := os.WriteFile(path, make([]byte, 8192), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenFile(path); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestOpenFileOtherMachine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "symbols")
+	st, err := OpenFile(path, WithSharedReaders())
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.StringToSequence("hat", true)
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+	good, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		field uintptr
+		value uint32
+		err   string
+	}{
+		{
+			name:  "byte order",
+			field: unsafe.Offsetof(fileHeader{}.byteOrder),
+			value: 0x04030201,
+			err:   "file was created on a machine with a different byte order",
+		},
+		{
+			name:  "int size",
+			field: unsafe.Offsetof(fileHeader{}.intSize),
+			value: 4,
+			err:   "file was created on a machine with 4-byte ints, not 8",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := bytes.Clone(good)
+			binary.NativeEndian.PutUint32(data[tt.field:], tt.value)
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := OpenFile(path); err == nil || !strings.HasSuffix(err.Error(), tt.err) {
+				t.Fatalf("expected error %q, got %v", tt.err, err)
+			}
+			if _, err := OpenSharedReader(path); err == nil || !strings.HasSuffix(err.Error(), tt.err) {
+				t.Fatalf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
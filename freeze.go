@@ -0,0 +1,106 @@
+package swisssymbols
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Freeze makes the SymbolTab read-only. Once frozen, any number of goroutines
+// may call SequenceToString, StringToSequence and the other methods that read
+// the SymbolTab at the same time without locking.
+//
+// Adding a string to a frozen SymbolTab is an error. StringToSequence panics
+// with ErrReadOnly if asked to add a new string, and TryStringToSequence
+// returns ErrReadOnly. ReadFrom, UnmarshalBinary, ApplyDelta, Replay,
+// ImportText and ImportJSONL also return ErrReadOnly. A SymbolTab can't be
+// unfrozen, but it can still be closed.
+//
+// Freeze itself must not be called while other goroutines are using the
+// SymbolTab.
+func (m *SymbolTab) Freeze() {
+	m.abandonSplit()
+	m.frozen = true
+	m.setLookupFlags()
+}
+
+// Frozen returns true if the SymbolTab has been frozen.
+func (m *SymbolTab) Frozen() bool {
+	return m.frozen
+}
+
+// Protect freezes the SymbolTab, and also asks the OS to make its memory
+// read-only, so that a stray write faults rather than corrupting the table.
+// For a file-backed SymbolTab the first page of the file stays writable so
+// that Sync and Close can update the header.
+func (m *SymbolTab) Protect() error {
+	m.Freeze()
+	if m.protected {
+		return nil
+	}
+	if err := m.mprotect(syscall.PROT_READ); err != nil {
+		// Don't leave some of the memory protected and some not.
+		m.mprotect(syscall.PROT_READ | syscall.PROT_WRITE)
+		return err
+	}
+	m.protected = true
+	return nil
+}
+
+// mprotect sets the protection of all the memory that holds the SymbolTab's
+// data.
+func (m *SymbolTab) mprotect(prot int) error {
+	if m.file != nil {
+		page := os.Getpagesize()
+		if m.file.mapped <= page {
+			return nil
+		}
+		return mprotect(unsafe.Slice((*byte)(m.file.ptr(page)), m.file.mapped-page), prot)
+	}
+
+	if err := mprotect(unsafe.Slice((*byte)(unsafe.Pointer(unsafe.SliceData(m.tables))), len(m.tables)*int(unsafe.Sizeof(m.tables[0]))), prot); err != nil {
+		return err
+	}
+	for i, t := range m.tables {
+		// A table may occupy several adjacent slots in the directory.
+		if i > 0 && m.tables[i-1] == t {
+			continue
+		}
+		if err := mprotect(unsafe.Slice((*byte)(unsafe.Pointer(t)), unsafe.Sizeof(*t)), prot); err != nil {
+			return err
+		}
+	}
+	for _, s := range m.ib.slabs {
+		if err := mprotect(unsafe.Slice((*byte)(unsafe.Pointer(unsafe.SliceData(s))), len(s)*int(unsafe.Sizeof(s[0]))), prot); err != nil {
+			return err
+		}
+	}
+	for _, c := range m.sb.chunks {
+		if c != nil {
+			if err := mprotect(c, prot); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mprotect changes the protection of b. We make the system call directly, as
+// in file.go, because syscall.Mprotect is missing on some platforms, such as
+// FreeBSD.
+func mprotect(b []byte, prot int) error {
+	if len(b) == 0 {
+		return nil
+	}
+	_, _, errno := syscall.Syscall(
+		syscall.SYS_MPROTECT,
+		uintptr(unsafe.Pointer(unsafe.SliceData(b))),
+		uintptr(len(b)),
+		uintptr(prot),
+	)
+	if errno != 0 {
+		return fmt.Errorf("protecting memory: %w", errno)
+	}
+	return nil
+}
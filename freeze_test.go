@@ -0,0 +1,174 @@
+package swisssymbols
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestFreeze(t *testing.T) {
+	st := New()
+	defer st.Close()
+	for i := range 100_000 {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	delta := deltaSince(t, st, 0)
+	st.Freeze()
+	if !st.Frozen() {
+		t.Fatal("expected table to be frozen")
+	}
+
+	// Any number of goroutines can read a frozen table.
+	var wg sync.WaitGroup
+	for w := range 4 {
+		wg.Go(func() {
+			for i := w; i < 100_000; i += 4 {
+				val := strconv.Itoa(i)
+				seq, found := st.StringToSequence(val, true)
+				if !found || seq != uint32(i+1) {
+					t.Errorf("looking up %q gave %d, %t", val, seq, found)
+					return
+				}
+				if got := st.SequenceToString(seq); got != val {
+					t.Errorf("seq %d gives %q, expected %q", seq, got, val)
+					return
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	if seq, found, err := st.TryStringToSequence("7", true); err != nil || !found || seq != 8 {
+		t.Fatalf("looking up existing string gave %d, %t, %v", seq, found, err)
+	}
+	if _, _, err := st.TryStringToSequence("new", true); err != ErrReadOnly {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+	if seq, found, err := st.TryStringToSequence("new", false); err != nil || found || seq != 0 {
+		t.Fatalf("looking up missing string gave %d, %t, %v", seq, found, err)
+	}
+	func() {
+		defer func() {
+			if p := recover(); p != ErrReadOnly {
+				t.Fatalf("expected ErrReadOnly panic, got %v", p)
+			}
+		}()
+		st.StringToSequence("new", true)
+	}()
+
+	tests := []struct {
+		name string
+		load func() error
+	}{
+		{"ApplyDelta", func() error { return st.ApplyDelta(bytes.NewReader(delta)) }},
+		{"UnmarshalBinary", func() error { return st.UnmarshalBinary(delta) }},
+		{"Replay", func() error { return st.Replay(strings.NewReader("")) }},
+		{"ImportText", func() error { return st.ImportText(strings.NewReader("100001 new\n")) }},
+		{"ImportJSONL", func() error { return st.ImportJSONL(strings.NewReader(`{"seq":100001,"val":"new"}`)) }},
+	}
+	for _, test := range tests {
+		if err := test.load(); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s: expected ErrReadOnly, got %v", test.name, err)
+		}
+	}
+	if st.Len() != 100_000 {
+		t.Fatalf("expected 100000 strings, have %d", st.Len())
+	}
+}
+
+func deltaSince(t *testing.T, st *SymbolTab, afterSeq uint32) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := st.ExportSince(&buf, afterSeq); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProtect(t *testing.T) {
+	st := New()
+	for i := range 100_000 {
+		st.StringToSequence(strconv.Itoa(i)+strings.Repeat("x", i%200), true)
+	}
+	if err := st.Protect(); err != nil {
+		t.Fatal(err)
+	}
+	if !st.Frozen() {
+		t.Fatal("expected table to be frozen")
+	}
+	for i := range 100_000 {
+		val := strconv.Itoa(i) + strings.Repeat("x", i%200)
+		if seq, found := st.StringToSequence(val, false); !found || seq != uint32(i+1) {
+			t.Fatalf("looking up %q gave %d, %t", val, seq, found)
+		}
+	}
+
+	// Writes to the table's memory fault.
+	writes := map[string]func(){
+		"directory": func() { st.tables[0] = nil },
+		"table":     func() { st.tables[0].used = 0 },
+		"intbank":   func() { st.ib.slabs[0][0] = 0 },
+		"strings":   func() { st.sb.chunks[0][chunkHeaderSize] = 0 },
+	}
+	for name, write := range writes {
+		if !faults(write) {
+			t.Errorf("writing to %s did not fault", name)
+		}
+	}
+
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProtectFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "symbols")
+	st, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 100_000 {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	if err := st.Protect(); err != nil {
+		t.Fatal(err)
+	}
+	if !faults(func() { st.tables[0].used = 0 }) {
+		t.Error("writing to a table did not fault")
+	}
+	if err := st.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err = OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if st.Frozen() {
+		t.Fatal("a reopened table should not be frozen")
+	}
+	for i := range 100_000 {
+		if seq, found := st.StringToSequence(strconv.Itoa(i), false); !found || seq != uint32(i+1) {
+			t.Fatalf("looking up %d gave %d, %t", i, seq, found)
+		}
+	}
+}
+
+// faults returns true if write causes a memory fault
+func faults(write func()) (faulted bool) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		faulted = recover() != nil
+	}()
+	write()
+	return false
+}
@@ -0,0 +1,71 @@
+package swisssymbols
+
+// WithGenerations makes the SymbolTab evict strings that are no longer being
+// looked up. Time is divided into generations, which the caller ends by
+// calling AdvanceGeneration. StringToSequence records that a string was used
+// in the current generation, and AdvanceGeneration deletes the strings that
+// have not been used in the last k generations.
+//
+// Strings that are loaded into the SymbolTab rather than added with
+// StringToSequence, for example by ReadFrom, count as used in the generation
+// they are loaded in. Lookups in a frozen SymbolTab are not recorded, so that
+// they remain safe to make from many goroutines.
+func WithGenerations(k int) Option {
+	if k < 1 {
+		panic("swisssymbols: WithGenerations needs at least 1 generation")
+	}
+	return func(m *SymbolTab) {
+		m.generations = uint32(k)
+	}
+}
+
+// Generation returns the current generation. Generations start at 0, and each
+// call to AdvanceGeneration moves on to the next.
+func (m *SymbolTab) Generation() uint32 {
+	return m.generation
+}
+
+// AdvanceGeneration ends the current generation, and deletes the strings that
+// have not been used by StringToSequence in it or the k-1 generations before
+// it, where k is the number given to WithGenerations. Strings that are held
+// (see Acquire) are not deleted. It returns the number of strings it deleted.
+//
+// If evicted is not nil it is called for each string before it is deleted, so
+// that the caller can invalidate anything that refers to it. val is only valid
+// during the call, and evicted must not change the SymbolTab.
+//
+// Evicting strings leaves gaps in the sequence numbers, so as with Delete the
+// SymbolTab can't be written with WriteTo, WriteImage and the like until
+// Compact is called.
+//
+// AdvanceGeneration checks the generation of every string, so it takes time in
+// proportion to the number of strings. It panics if the SymbolTab was not
+// created WithGenerations, and in the same cases as Delete.
+func (m *SymbolTab) AdvanceGeneration(evicted func(seq uint32, val string)) int {
+	if m.generations == 0 {
+		panic("swisssymbols: AdvanceGeneration called on a SymbolTab created without WithGenerations")
+	}
+	m.checkDelete()
+
+	var n int
+	for seq := uint32(1); seq <= uint32(m.count); seq++ {
+		offset := m.ib.lookup(seq)
+		if offset < 0 || m.generation-m.used.get(seq) < m.generations || m.refs.get(seq) != 0 {
+			continue
+		}
+		val := m.sb.Get(offset)
+		if evicted != nil {
+			evicted(seq, val)
+		}
+		m.delete(m.hash(val), seq, offset)
+		n++
+	}
+	m.generation++
+	return n
+}
+
+// touch records that the string with sequence number seq has been used in the
+// current generation.
+func (m *SymbolTab) touch(seq uint32) {
+	*m.used.ref(seq) = m.generation
+}
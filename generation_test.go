@@ -0,0 +1,111 @@
+package swisssymbols
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestGenerations(t *testing.T) {
+	st := New(WithGenerations(2))
+	defer st.Close()
+
+	type evicted struct {
+		seq uint32
+		val string
+	}
+	var got []evicted
+	advance := func() {
+		got = got[:0]
+		st.AdvanceGeneration(func(seq uint32, val string) {
+			got = append(got, evicted{seq: seq, val: val})
+		})
+	}
+
+	// Generation 0
+	for _, val := range []string{"a", "b", "c", "d"} {
+		st.StringToSequence(val, true)
+	}
+	advance()
+	if len(got) != 0 {
+		t.Fatalf("evicted %v after one generation", got)
+	}
+
+	// Generation 1
+	st.StringToSequence("a", false)
+	st.BytesToSequence([]byte("b"), false)
+	advance()
+	if len(got) != 0 {
+		t.Fatalf("evicted %v after two generations", got)
+	}
+
+	// Generation 2. c and d were last used in generation 0.
+	st.StringsToSequences([]string{"a", "e"}, true, make([]uint32, 2), nil)
+	advance()
+	if exp := []evicted{{3, "c"}, {4, "d"}}; !slices.Equal(got, exp) {
+		t.Fatalf("evicted %v, expected %v", got, exp)
+	}
+	if st.Len() != 3 {
+		t.Fatalf("expected 3 strings, have %d", st.Len())
+	}
+	if _, found := st.StringToSequence("c", false); found {
+		t.Fatal("c was not evicted")
+	}
+
+	// Generation 3. b was last used in generation 1.
+	advance()
+	if exp := []evicted{{2, "b"}}; !slices.Equal(got, exp) {
+		t.Fatalf("evicted %v, expected %v", got, exp)
+	}
+	if st.Generation() != 4 {
+		t.Fatalf("expected generation 4, have %d", st.Generation())
+	}
+
+	// Generation 4. a and e were last used in generation 2.
+	if n := st.AdvanceGeneration(nil); n != 2 || st.Len() != 0 {
+		t.Fatalf("evicted %d strings, leaving %d", n, st.Len())
+	}
+}
+
+func TestGenerationsStreaming(t *testing.T) {
+	st := New(WithGenerations(3), WithSequenceReuse())
+	defer st.Close()
+
+	// Each generation uses a sliding window of strings. Only the strings
+	// used in the last 3 generations should remain.
+	const perGeneration = 10_000
+	for g := range 50 {
+		for i := range 2 * perGeneration {
+			st.StringToSequence(strconv.Itoa(g*perGeneration+i), true)
+		}
+		st.AdvanceGeneration(nil)
+
+		// Generation g used strings from g*perGeneration to
+		// (g+2)*perGeneration.
+		first := max(0, g-2) * perGeneration
+		if exp := (g+2)*perGeneration - first; st.Len() != exp {
+			t.Fatalf("generation %d: expected %d strings, have %d", g, exp, st.Len())
+		}
+		for seq, val := range st.All() {
+			if i, _ := strconv.Atoi(val); i < first {
+				t.Fatalf("generation %d: %q (%d) was not evicted", g, val, seq)
+			}
+		}
+	}
+	// Sequence numbers are reused, so they stay in the range of the strings
+	// in use.
+	if st.count > 5*perGeneration {
+		t.Fatalf("sequence numbers reached %d", st.count)
+	}
+}
+
+func TestGenerationsNotEnabled(t *testing.T) {
+	st := New()
+	defer st.Close()
+	defer func() {
+		if p := recover(); p == nil {
+			t.Fatal("expected a panic")
+		}
+	}()
+	st.AdvanceGeneration(nil)
+}
@@ -10,6 +10,11 @@ const (
 	emptyGroupControl  = 0x8080808080808080
 	controlHashMask    = 0x7F
 	groupControlExpand = 0x0101010101010101
+
+	// A control byte is empty if it is controlEmpty, deleted if it is
+	// controlDeleted, and full if its top bit is clear.
+	controlEmpty   = 0x80
+	controlDeleted = 0xFE
 )
 
 func (g *group) init() {
@@ -33,7 +38,16 @@ func (gc groupControl) findMatches(ctrlHash hashValue) groupBits {
 }
 
 // findEmpty returns a bits mask of which entries in the group are empty.
+// Deleted entries are not empty.
 func (gc groupControl) findEmpty() groupBits {
+	// Empty and deleted bytes both have the top bit set, but only deleted
+	// bytes have bit 1 set. Shifting left by 6 moves bit 1 to the top.
+	return groupBits(uint64(gc) &^ (uint64(gc) << 6) & uint64(emptyGroupControl))
+}
+
+// findEmptyOrDeleted returns a bits mask of which entries in the group are
+// empty or deleted, and so can take a new entry.
+func (gc groupControl) findEmptyOrDeleted() groupBits {
 	return groupBits(uint64(gc) & uint64(emptyGroupControl))
 }
 
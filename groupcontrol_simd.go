@@ -14,6 +14,11 @@ var (
 const (
 	controlHashMask    = 0x7F
 	groupControlExpand = 0x0101010101010101
+
+	// A control byte is empty if it is controlEmpty, deleted if it is
+	// controlDeleted, and full if its top bit is clear.
+	controlEmpty   = 0x80
+	controlDeleted = 0xFE
 )
 
 func (g *group) init() {
@@ -30,7 +35,15 @@ func (gc groupControl) findMatches(hash hashValue) groupBits {
 }
 
 // findEmpty returns a bits mask of which entries in the group are empty.
+// Deleted entries are not empty.
 func (gc groupControl) findEmpty() groupBits {
+	vec := archsimd.LoadUint8x16SlicePart(gc[:])
+	return groupBits(vec.Equal(emptyGroupControl).ToBits())
+}
+
+// findEmptyOrDeleted returns a bits mask of which entries in the group are
+// empty or deleted, and so can take a new entry.
+func (gc groupControl) findEmptyOrDeleted() groupBits {
 	vec := archsimd.LoadUint8x16SlicePart(gc[:])
 	return groupBits(vec.And(emptyGroupControl).Equal(emptyGroupControl).ToBits())
 }
@@ -89,6 +89,11 @@ func TestGroupFindEmpty(t *testing.T) {
 			control:  groupControl{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80},
 			expected: 0b11111111,
 		},
+		{
+			name:     "deleted are not empty",
+			control:  groupControl{0x80, 0xFE, 0x03, 0x04, 0x05, 0x06, 0xFE, 0x80},
+			expected: 0b10000001,
+		},
 	}
 
 	for _, tt := range tests {
@@ -79,6 +79,11 @@ func TestGroupFindEmpty(t *testing.T) {
 			control:  0x8080808080808080,
 			expected: 0x8080808080808080,
 		},
+		{
+			name:     "deleted are not empty",
+			control:  0x80FE_0304_0506_FE80,
+			expected: 0b1000000000000000000000000000000000000000000000000000000010000000,
+		},
 	}
 
 	for _, tt := range tests {
@@ -90,3 +95,31 @@ func TestGroupFindEmpty(t *testing.T) {
 		})
 	}
 }
+
+func TestGroupFindEmptyOrDeleted(t *testing.T) {
+	tests := []struct {
+		name     string
+		control  groupControl
+		expected groupBits
+	}{
+		{
+			name:     "none",
+			control:  0x0102030405060708,
+			expected: 0x0,
+		},
+		{
+			name:     "empty and deleted",
+			control:  0x80FE_0304_0506_0780,
+			expected: 0b1000000010000000000000000000000000000000000000000000000010000000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.control.findEmptyOrDeleted()
+			if result != tt.expected {
+				t.Errorf("expected bits %08b, got %08b", tt.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package swisssymbols
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"strconv"
+	"unsafe"
+)
+
+// Hasher selects the hash function a SymbolTab uses.
+type Hasher uint8
+
+const (
+	// RuntimeHasher uses the Go runtime's hash function. This is the fastest
+	// option, but the runtime seeds its hash randomly in each process, so the
+	// layout of the tables means nothing outside the process. It is the
+	// default for SymbolTabs created with New.
+	RuntimeHasher Hasher = iota
+	// StableHasher uses StableHash32, which gives the same result in every
+	// process. It is the default for file-backed SymbolTabs, and the only
+	// choice allowed for them.
+	StableHasher
+)
+
+func (h Hasher) String() string {
+	switch h {
+	case RuntimeHasher:
+		return "RuntimeHasher"
+	case StableHasher:
+		return "StableHasher"
+	}
+	return "Hasher(" + strconv.Itoa(int(h)) + ")"
+}
+
+// hash returns the hash of key using the SymbolTab's Hasher
+func (m *SymbolTab) hash(key string) hashValue {
+	return m.hasher.hash(key)
+}
+
+// hash returns the hash of key using h. Everything that hashes keys for a
+// table calls this, so that they all agree.
+func (h Hasher) hash(key string) hashValue {
+	if h == StableHasher {
+		return hashValue(StableHash32(key))
+	}
+	return runtimeHash(key)
+}
+
+func runtimeHash(key string) hashValue {
+	return hashValue(runtime_memhash(
+		unsafe.Pointer(unsafe.StringData(key)),
+		0,
+		uintptr(len(key)),
+	))
+}
+
+// We use the runtime's map hash function without the overhead of using
+// hash/maphash
+//
+//go:linkname runtime_memhash runtime.memhash
+//go:noescape
+func runtime_memhash(p unsafe.Pointer, seed, s uintptr) uintptr
+
+// StableHash32 is a deterministic 32 bit string hash. Unlike the runtime's
+// hash it gives the same result in every process.
+//
+// Its results will never change, as files depend on them.
+func StableHash32(key string) uint32 {
+	h := StableHash64(key, 0)
+	return uint32(h ^ h>>32)
+}
+
+const (
+	wyp0 = 0xa0761d6478bd642f
+	wyp1 = 0xe7037ed1a0b428db
+	wyp2 = 0x8ebc6af09c88c6e3
+	wyp3 = 0x589965cc75374cc3
+)
+
+// StableHash64 is a deterministic, seeded 64 bit string hash. Unlike the
+// runtime's hash it gives the same result in every process. It is based on
+// wyhash (https://github.com/wangyi-fudan/wyhash).
+//
+// Its results will never change, as files depend on them.
+func StableHash64(key string, seed uint64) uint64 {
+	p := unsafe.Pointer(unsafe.StringData(key))
+	l := uintptr(len(key))
+
+	seed ^= wymix(seed^wyp0, wyp1)
+
+	var a, b uint64
+	switch {
+	case l == 0:
+	case l < 4:
+		a = uint64(*(*byte)(p))<<16 | uint64(*(*byte)(unsafe.Add(p, l>>1)))<<8 | uint64(*(*byte)(unsafe.Add(p, l-1)))
+	case l <= 16:
+		q := (l >> 3) << 2
+		a = r4(p, 0)<<32 | r4(p, q)
+		b = r4(p, l-4)<<32 | r4(p, l-4-q)
+	default:
+		q := p
+		i := l
+		if i > 48 {
+			see1, see2 := seed, seed
+			for ; i > 48; i -= 48 {
+				seed = wymix(r8(q, 0)^wyp1, r8(q, 8)^seed)
+				see1 = wymix(r8(q, 16)^wyp2, r8(q, 24)^see1)
+				see2 = wymix(r8(q, 32)^wyp3, r8(q, 40)^see2)
+				q = unsafe.Add(q, 48)
+			}
+			seed ^= see1 ^ see2
+		}
+		for ; i > 16; i -= 16 {
+			seed = wymix(r8(q, 0)^wyp1, r8(q, 8)^seed)
+			q = unsafe.Add(q, 16)
+		}
+		// Read the last 16 bytes of the key. These may overlap with bytes
+		// we've already consumed.
+		a = r8(p, l-16)
+		b = r8(p, l-8)
+	}
+
+	a ^= wyp1
+	b ^= seed
+	hi, lo := bits.Mul64(a, b)
+	return wymix(lo^wyp0^uint64(len(key)), hi^wyp1)
+}
+
+func wymix(a, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	return hi ^ lo
+}
+
+// r8 reads 8 bytes at p+offset as a little-endian integer. On little-endian
+// systems the compiler turns this into a single load.
+func r8(p unsafe.Pointer, offset uintptr) uint64 {
+	return binary.LittleEndian.Uint64(unsafe.Slice((*byte)(unsafe.Add(p, offset)), 8))
+}
+
+// r4 reads 4 bytes at p+offset as a little-endian integer.
+func r4(p unsafe.Pointer, offset uintptr) uint64 {
+	return uint64(binary.LittleEndian.Uint32(unsafe.Slice((*byte)(unsafe.Add(p, offset)), 4)))
+}
@@ -0,0 +1,148 @@
+package swisssymbols
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestStableHash(t *testing.T) {
+	// The results of StableHash32 must never change, as they are stored in
+	// files. If this test fails you've broken existing files.
+	tests := []struct {
+		key      string
+		expected uint32
+	}{
+		{key: "", expected: 0xe6b487d7},
+		{key: "a", expected: 0x21008002},
+		{key: "abc", expected: 0xc9f59da5},
+		{key: "abcd", expected: 0xd26ac3a3},
+		{key: "hello world!", expected: 0x7f0eac2d},
+		{key: "0123456789abcdef", expected: 0xfb783505},
+		{key: "0123456789abcdefg", expected: 0xb2f6f966},
+		{key: strings.Repeat("swiss", 20), expected: 0x74536419},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if h := StableHash32(tt.key); h != tt.expected {
+				t.Errorf("expected %#x, got %#x", tt.expected, h)
+			}
+		})
+	}
+}
+
+func TestStableHash64(t *testing.T) {
+	// These values were computed on a little-endian machine. Every machine
+	// must give the same results, whatever its byte order.
+	tests := []struct {
+		key      string
+		seed     uint64
+		expected uint64
+	}{
+		{key: "", seed: 0, expected: 0x409638ee2bde459},
+		{key: "", seed: 42, expected: 0x72014e4eed7eeb7d},
+		{key: "a", seed: 0, expected: 0x28d2053309d28531},
+		{key: "abc", seed: 42, expected: 0x729d41f062dc5b37},
+		{key: "abcd", seed: 0, expected: 0x48dfe2b09ab52113},
+		{key: "hello world!", seed: 42, expected: 0x8ee1c69a7985e65c},
+		{key: "0123456789abcdef", seed: 0, expected: 0xc304e72c387cd229},
+		{key: "0123456789abcdefg", seed: 42, expected: 0xffa80f957865c49c},
+		{key: strings.Repeat("swiss", 20), seed: 0, expected: 0x8a340650fe676249},
+		{key: strings.Repeat("swiss", 20), seed: 42, expected: 0x593b6ce6503c085a},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key+"/"+strconv.FormatUint(tt.seed, 10), func(t *testing.T) {
+			if h := StableHash64(tt.key, tt.seed); h != tt.expected {
+				t.Errorf("expected %#x, got %#x", tt.expected, h)
+			}
+		})
+	}
+}
+
+func TestStableHash64Seed(t *testing.T) {
+	if StableHash64("hat", 0) == StableHash64("hat", 1) {
+		t.Error("expected different seeds to give different hashes")
+	}
+	if StableHash64("hat", 42) != StableHash64("hat", 42) {
+		t.Error("expected the same seed to give the same hash")
+	}
+}
+
+func TestHasherLayout(t *testing.T) {
+	// With StableHasher, the same strings inserted in the same order give
+	// exactly the same tables.
+	st1 := New(WithHasher(StableHasher))
+	defer st1.Close()
+	st2 := New(WithHasher(StableHasher))
+	defer st2.Close()
+	for i := range 10_000 {
+		st1.StringToSequence(strconv.Itoa(i), true)
+		st2.StringToSequence(strconv.Itoa(i), true)
+	}
+	if st1.tableCount != st2.tableCount {
+		t.Fatalf("table counts differ: %d and %d", st1.tableCount, st2.tableCount)
+	}
+	for i := range st1.tables {
+		if *st1.tables[i] != *st2.tables[i] {
+			t.Fatalf("table %d differs", i)
+		}
+	}
+	for i := range 10_000 {
+		if seq, found := st1.StringToSequence(strconv.Itoa(i), false); !found || seq != uint32(i+1) {
+			t.Fatalf("expected seq %d for %d, got %d (found=%t)", i+1, i, seq, found)
+		}
+	}
+}
+
+func TestStableHashSpread(t *testing.T) {
+	// Check that similar keys get different hashes, and that the bits we use
+	// to pick tables and groups vary.
+	seen := make(map[uint32]struct{})
+	var top, bottom [16]int
+	for i := range 100_000 {
+		h := StableHash32("key" + strings.Repeat("x", i%40) + string(rune('a'+i%26)) + strconv.Itoa(i))
+		seen[h] = struct{}{}
+		top[h>>28]++
+		bottom[h&0xF]++
+	}
+	if len(seen) < 99_990 {
+		t.Errorf("too many collisions: %d distinct hashes", len(seen))
+	}
+	for i := range top {
+		if top[i] < 5000 || bottom[i] < 5000 {
+			t.Errorf("poor spread in bucket %d: top %d, bottom %d", i, top[i], bottom[i])
+		}
+	}
+}
+
+func BenchmarkHasher(b *testing.B) {
+	symbols := make([]string, 1_000_000)
+	for i := range symbols {
+		symbols[i] = strconv.Itoa(i)
+	}
+	for _, h := range []Hasher{RuntimeHasher, StableHasher} {
+		b.Run(h.String(), func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				st := New(WithHasher(h))
+				for _, sym := range symbols {
+					st.StringToSequence(sym, true)
+				}
+				st.Close()
+			}
+		})
+	}
+}
+
+func BenchmarkStableHash(b *testing.B) {
+	keys := []string{"a", "hello", "0123456789abcdef", strings.Repeat("swiss", 20)}
+	for _, key := range keys {
+		b.Run(strconv.Itoa(len(key)), func(b *testing.B) {
+			for b.Loop() {
+				StableHash32(key)
+			}
+		})
+	}
+}
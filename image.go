@@ -0,0 +1,315 @@
+package swisssymbols
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/bits"
+	"unsafe"
+)
+
+// An image is a read-only form of a SymbolTab that can be used directly from
+// a []byte, such as one embedded in a binary with go:embed. The format is
+//
+//	magic     [4]byte "SSIM"
+//	version   uint32
+//	count     uint32 number of strings
+//	indexSize uint32 size of the index, which depends on the index kind
+//	dataSize  uint64 total length of the strings
+//	checksum  uint32 CRC-32C of everything after the header
+//	indexKind uint32
+//	offsets   [count+1]uint32 string seq is data[offsets[seq-1]:offsets[seq]]
+//	index     the index, which depends on the index kind
+//	data      [dataSize]byte
+//
+// All integers are little-endian. For the probe index kind the index is an
+// open-addressed hash table of indexSize uint32 sequence numbers, with 0
+// marking an empty slot. indexSize is a power of 2. A string's probe sequence
+// starts at StableHash32(string) & (indexSize-1) and moves linearly. The
+// perfect index kind is described in perfect.go.
+const (
+	imageMagic      = "SSIM"
+	imageVersion    = 1
+	imageHeaderSize = 32
+)
+
+const (
+	imageIndexProbe = iota
+	imageIndexPerfect
+)
+
+// ErrReadOnly is returned when trying to add a string to a read-only symbol
+// table.
+var ErrReadOnly = errors.New("swisssymbols: symbol table is read-only")
+
+// Image is a read-only symbol table that works directly from a serialized
+// image held in a []byte. Loading an image does not copy it. Write an image
+// with SymbolTab.WriteImage, and load it with LoadImage.
+//
+// As Image never changes, it is safe for concurrent use.
+type Image struct {
+	count   uint32
+	kind    uint32
+	offsets []byte
+	data    []byte
+
+	// for the probe index
+	mask  uint32
+	index []byte
+
+	// for the perfect index
+	seed    uint64
+	buckets uint32
+	disp    []byte
+	seqs    []byte
+	fps     []byte
+}
+
+// WriteImage writes an image of the SymbolTab to w. Load the image with
+// LoadImage. The total length of the strings in an image must be less than
+// 4GB.
+func (m *SymbolTab) WriteImage(w io.Writer) (n int64, err error) {
+	return m.writeImage(w, imageIndexProbe)
+}
+
+func (m *SymbolTab) writeImage(w io.Writer, kind uint32) (n int64, err error) {
+	if m.deleted > 0 {
+		return 0, ErrHasDeletions
+	}
+	var dataSize uint64
+	for seq := uint32(1); seq <= uint32(m.count); seq++ {
+		dataSize += uint64(len(m.SequenceToString(seq)))
+	}
+	if dataSize > 1<<32-1 {
+		return 0, fmt.Errorf("strings total %d bytes, which is too big for an image", dataSize)
+	}
+
+	var indexSize uint32
+	var index []byte
+	if kind == imageIndexPerfect {
+		indexSize, index, err = m.perfectIndex()
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		indexSize, index = m.probeIndex()
+	}
+
+	// The checksum goes in the header, so we make a first pass over the
+	// body just to calculate it.
+	crc := crc32.New(crcTable)
+	if err := m.writeImageBody(crc, index); err != nil {
+		return 0, err
+	}
+
+	var hdr [imageHeaderSize]byte
+	copy(hdr[:], imageMagic)
+	binary.LittleEndian.PutUint32(hdr[4:], imageVersion)
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(m.count))
+	binary.LittleEndian.PutUint32(hdr[12:], indexSize)
+	binary.LittleEndian.PutUint64(hdr[16:], dataSize)
+	binary.LittleEndian.PutUint32(hdr[24:], crc.Sum32())
+	binary.LittleEndian.PutUint32(hdr[28:], kind)
+
+	cw := &countingWriter{w: w}
+	if _, err := cw.Write(hdr[:]); err != nil {
+		return cw.n, err
+	}
+	err = m.writeImageBody(cw, index)
+	return cw.n, err
+}
+
+// probeIndex builds an open-addressed index for the strings in the SymbolTab.
+// It returns the number of slots and the index section of the image.
+func (m *SymbolTab) probeIndex() (indexSize uint32, index []byte) {
+	// We keep the index at most half full so that probe sequences are short.
+	indexSize = uint32(1) << bits.Len32(uint32(m.count*2))
+	index = make([]byte, indexSize*4)
+	mask := indexSize - 1
+	for seq := uint32(1); seq <= uint32(m.count); seq++ {
+		slot := StableHash32(m.SequenceToString(seq)) & mask
+		for binary.LittleEndian.Uint32(index[slot*4:]) != 0 {
+			slot = (slot + 1) & mask
+		}
+		binary.LittleEndian.PutUint32(index[slot*4:], seq)
+	}
+	return indexSize, index
+}
+
+// writeImageBody writes everything in the image after the header
+func (m *SymbolTab) writeImageBody(w io.Writer, index []byte) error {
+	bw := bufio.NewWriter(w)
+	var b [4]byte
+	var offset uint32
+	bw.Write(b[:])
+	for seq := uint32(1); seq <= uint32(m.count); seq++ {
+		offset += uint32(len(m.SequenceToString(seq)))
+		binary.LittleEndian.PutUint32(b[:], offset)
+		bw.Write(b[:])
+	}
+	bw.Write(index)
+	for seq := uint32(1); seq <= uint32(m.count); seq++ {
+		bw.WriteString(m.SequenceToString(seq))
+	}
+	return bw.Flush()
+}
+
+// LoadImage returns an Image that answers lookups directly from data. data
+// must not be modified while the Image is in use.
+func LoadImage(data []byte) (*Image, error) {
+	if len(data) < imageHeaderSize {
+		return nil, errors.New("image is too short")
+	}
+	if string(data[:4]) != imageMagic {
+		return nil, errors.New("not a swisssymbols image")
+	}
+	if v := binary.LittleEndian.Uint32(data[4:]); v != imageVersion {
+		return nil, fmt.Errorf("unsupported image version %d", v)
+	}
+	count := binary.LittleEndian.Uint32(data[8:])
+	indexSize := binary.LittleEndian.Uint32(data[12:])
+	dataSize := binary.LittleEndian.Uint64(data[16:])
+	sum := binary.LittleEndian.Uint32(data[24:])
+
+	kind := binary.LittleEndian.Uint32(data[28:])
+
+	var indexLen uint64
+	switch kind {
+	case imageIndexProbe:
+		if indexSize == 0 || indexSize&(indexSize-1) != 0 || indexSize <= count {
+			return nil, fmt.Errorf("image index size %d is invalid", indexSize)
+		}
+		indexLen = uint64(indexSize) * 4
+	case imageIndexPerfect:
+		if indexSize != perfectBuckets(count) {
+			return nil, fmt.Errorf("image index size %d is invalid", indexSize)
+		}
+		indexLen = perfectIndexLen(count, indexSize)
+	default:
+		return nil, fmt.Errorf("unsupported image index kind %d", kind)
+	}
+	offsetsEnd := imageHeaderSize + (uint64(count)+1)*4
+	indexEnd := offsetsEnd + indexLen
+	end := indexEnd + dataSize
+	if uint64(len(data)) != end {
+		return nil, fmt.Errorf("image should be %d bytes but is %d", end, len(data))
+	}
+	if crc32.Checksum(data[imageHeaderSize:], crcTable) != sum {
+		return nil, ErrBadChecksum
+	}
+
+	im := &Image{
+		count:   count,
+		kind:    kind,
+		offsets: data[imageHeaderSize:offsetsEnd],
+		data:    data[indexEnd:end],
+	}
+
+	// Check the image is consistent, so that lookups can't go out of range
+	var last uint32
+	for seq := range count + 1 {
+		offset := im.offset(seq)
+		if offset < last || (seq == 0 && offset != 0) {
+			return nil, fmt.Errorf("image offset %d is out of order", seq)
+		}
+		last = offset
+	}
+	if uint64(last) != dataSize {
+		return nil, fmt.Errorf("image offsets end at %d, but data is %d bytes", last, dataSize)
+	}
+
+	index := data[offsetsEnd:indexEnd]
+	if kind == imageIndexPerfect {
+		if err := im.loadPerfectIndex(index, indexSize); err != nil {
+			return nil, err
+		}
+		return im, nil
+	}
+
+	im.mask = indexSize - 1
+	im.index = index
+	// Each sequence number may only appear once. As there are more slots than
+	// strings, this means there is always an empty slot to end a probe.
+	seen := make([]uint64, (count+64)/64)
+	for slot := range indexSize {
+		seq := im.slot(slot)
+		if seq > count {
+			return nil, fmt.Errorf("image index slot %d is out of range", slot)
+		}
+		if seq != 0 && seen[seq/64]&(1<<(seq%64)) != 0 {
+			return nil, fmt.Errorf("image index has sequence %d in more than one slot", seq)
+		}
+		seen[seq/64] |= 1 << (seq % 64)
+	}
+	return im, nil
+}
+
+// LoadImageString is like LoadImage, but takes the image as a string, such as
+// a string constant in generated code. It does not copy the string.
+func LoadImageString(data string) (*Image, error) {
+	return LoadImage(unsafe.Slice(unsafe.StringData(data), len(data)))
+}
+
+// Len returns the number of strings in the Image
+func (im *Image) Len() int {
+	return int(im.count)
+}
+
+// SequenceToString returns the string with sequence number seq. It returns an
+// empty string if there is no such string.
+func (im *Image) SequenceToString(seq uint32) string {
+	if seq == 0 || seq > im.count {
+		return ""
+	}
+	b := im.data[im.offset(seq-1):im.offset(seq)]
+	return unsafe.String(unsafe.SliceData(b), len(b))
+}
+
+// StringToSequence looks up the string val and returns its sequence number.
+// found indicates whether val is present. An Image is read-only, so if val is
+// not present and addNew is true StringToSequence panics with ErrReadOnly. Use
+// TryStringToSequence to get an error instead.
+func (im *Image) StringToSequence(val string, addNew bool) (seq uint32, found bool) {
+	seq, found, err := im.TryStringToSequence(val, addNew)
+	if err != nil {
+		panic(err)
+	}
+	return seq, found
+}
+
+// TryStringToSequence is like StringToSequence, but returns ErrReadOnly if
+// val is not present and addNew is true.
+func (im *Image) TryStringToSequence(val string, addNew bool) (seq uint32, found bool, err error) {
+	if im.kind == imageIndexPerfect {
+		if seq := im.perfectLookup(val); seq != 0 {
+			return seq, true, nil
+		}
+	} else if seq := im.probeLookup(val); seq != 0 {
+		return seq, true, nil
+	}
+	if addNew {
+		return 0, false, ErrReadOnly
+	}
+	return 0, false, nil
+}
+
+// probeLookup returns the sequence number for val, or 0 if it isn't present.
+func (im *Image) probeLookup(val string) uint32 {
+	for slot := StableHash32(val) & im.mask; ; slot = (slot + 1) & im.mask {
+		seq := im.slot(slot)
+		if seq == 0 || im.SequenceToString(seq) == val {
+			return seq
+		}
+	}
+}
+
+func (im *Image) offset(i uint32) uint32 {
+	return binary.LittleEndian.Uint32(im.offsets[i*4:])
+}
+
+func (im *Image) slot(i uint32) uint32 {
+	return binary.LittleEndian.Uint32(im.index[i*4:])
+}
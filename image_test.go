@@ -0,0 +1,233 @@
+package swisssymbols
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"testing"
+)
+
+func TestImage(t *testing.T) {
+	st := New()
+	defer st.Close()
+	for _, val := range awkwardStrings {
+		st.StringToSequence(val, true)
+	}
+	for i := range 10_000 {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+
+	for _, kind := range imageKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := kind.write(st, &buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != int64(buf.Len()) {
+				t.Fatalf("wrote %d bytes, but returned %d", buf.Len(), n)
+			}
+
+			im, err := LoadImage(buf.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertImageMatches(t, st, im)
+		})
+	}
+}
+
+var imageKinds = []struct {
+	name  string
+	write func(st *SymbolTab, w io.Writer) (int64, error)
+}{
+	{name: "probe", write: (*SymbolTab).WriteImage},
+	{name: "perfect", write: (*SymbolTab).WritePerfectImage},
+}
+
+// assertImageMatches checks that an Image holds the same strings as a
+// SymbolTab, and that it is read-only.
+func assertImageMatches(t *testing.T, st *SymbolTab, im *Image) {
+	t.Helper()
+	if im.Len() != st.Len() {
+		t.Fatalf("expected %d strings, got %d", st.Len(), im.Len())
+	}
+	for seq := uint32(1); seq <= uint32(st.Len()); seq++ {
+		val := st.SequenceToString(seq)
+		if s := im.SequenceToString(seq); s != val {
+			t.Fatalf("expected %q for seq %d, got %q", val, seq, s)
+		}
+		if s, found := im.StringToSequence(val, false); !found || s != seq {
+			t.Fatalf("expected seq %d for %q, got %d (found=%t)", seq, val, s, found)
+		}
+	}
+
+	if seq, found := im.StringToSequence("missing", false); found || seq != 0 {
+		t.Fatalf("did not expect to find missing, got %d", seq)
+	}
+	if s := im.SequenceToString(uint32(st.Len() + 1)); s != "" {
+		t.Fatalf("expected empty string, got %q", s)
+	}
+
+	// Existing strings can be looked up with addNew, but new ones can't be
+	// added.
+	if seq, found, err := im.TryStringToSequence("hat", true); err != nil || !found || seq != 1 {
+		t.Fatalf("expected hat at 1, got %d, %t, %v", seq, found, err)
+	}
+	if _, _, err := im.TryStringToSequence("missing", true); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+	func() {
+		defer func() {
+			if r := recover(); r != ErrReadOnly {
+				t.Fatalf("expected panic with ErrReadOnly, got %v", r)
+			}
+		}()
+		im.StringToSequence("missing", true)
+	}()
+}
+
+func TestImageEmpty(t *testing.T) {
+	st := New()
+	defer st.Close()
+	for _, kind := range imageKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if _, err := kind.write(st, &buf); err != nil {
+				t.Fatal(err)
+			}
+			im, err := LoadImage(buf.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if im.Len() != 0 {
+				t.Fatalf("expected no strings, got %d", im.Len())
+			}
+			if _, found := im.StringToSequence("", false); found {
+				t.Fatal("did not expect to find anything")
+			}
+		})
+	}
+}
+
+func TestImageErrors(t *testing.T) {
+	st := New()
+	defer st.Close()
+	st.StringToSequence("hat", true)
+	st.StringToSequence("coat", true)
+	var buf bytes.Buffer
+	if _, err := st.WriteImage(&buf); err != nil {
+		t.Fatal(err)
+	}
+	good := buf.Bytes()
+
+	tests := []struct {
+		name string
+		data func() []byte
+		err  string
+	}{
+		{
+			name: "short",
+			data: func() []byte { return good[:10] },
+			err:  "image is too short",
+		},
+		{
+			name: "magic",
+			data: func() []byte { return append([]byte("XXXX"), good[4:]...) },
+			err:  "not a swisssymbols image",
+		},
+		{
+			name: "truncated",
+			data: func() []byte { return good[:len(good)-1] },
+			err:  "image should be 83 bytes but is 82",
+		},
+		{
+			name: "kind",
+			data: func() []byte {
+				data := bytes.Clone(good)
+				data[28] = 7
+				return data
+			},
+			err: "unsupported image index kind 7",
+		},
+		{
+			name: "corrupt",
+			data: func() []byte {
+				data := bytes.Clone(good)
+				data[len(data)-1] ^= 1
+				return data
+			},
+			err: ErrBadChecksum.Error(),
+		},
+		{
+			// With every slot full a probe for a missing string would
+			// never end.
+			name: "duplicate",
+			data: func() []byte {
+				data := bytes.Clone(good)
+				for slot := range 8 {
+					binary.LittleEndian.PutUint32(data[44+slot*4:], 1)
+				}
+				binary.LittleEndian.PutUint32(data[24:], crc32.Checksum(data[imageHeaderSize:], crcTable))
+				return data
+			},
+			err: "image index has sequence 1 in more than one slot",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadImage(tt.data())
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func BenchmarkImage(b *testing.B) {
+	const n = 1_000_000
+	st := New()
+	defer st.Close()
+	for i := range n {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	keys := make([]string, 2*n)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+
+	for _, kind := range imageKinds {
+		var buf bytes.Buffer
+		if _, err := kind.write(st, &buf); err != nil {
+			b.Fatal(err)
+		}
+		im, err := LoadImage(buf.Bytes())
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(kind.name, func(b *testing.B) {
+			b.Run("hit", func(b *testing.B) {
+				b.ReportAllocs()
+				b.ReportMetric(float64(buf.Len())/n, "bytes/string")
+				for i := range b.N {
+					if _, found := im.StringToSequence(keys[i%n], false); !found {
+						b.Fatalf("not found %d", i)
+					}
+				}
+			})
+			b.Run("miss", func(b *testing.B) {
+				b.ReportAllocs()
+				for i := range b.N {
+					if _, found := im.StringToSequence(keys[n+i%n], false); found {
+						b.Fatalf("found %d", i)
+					}
+				}
+			})
+		})
+	}
+}
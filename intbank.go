@@ -1,18 +1,18 @@
 package swisssymbols
 
-import (
-	"github.com/philpearl/mmap"
-)
+import "github.com/philpearl/mmap"
 
 const intbanksize = 1 << 12
 
 type intbank struct {
+	mem   allocator
 	slabs [][]int
 }
 
 func (ib *intbank) close() {
+	mem := memOrAnon(ib.mem)
 	for _, s := range ib.slabs {
-		mmap.Free(s)
+		mem.freeSlab(s)
 	}
 	ib.slabs = nil
 }
@@ -23,8 +23,7 @@ func (ib *intbank) save(sequence uint32, offset int) {
 	slabOffset := int(sequence % intbanksize)
 
 	for len(ib.slabs) <= slabNo {
-		ns, _ := mmap.Alloc[int](intbanksize)
-		ib.slabs = append(ib.slabs, ns)
+		ib.slabs = append(ib.slabs, memOrAnon(ib.mem).allocSlab())
 	}
 
 	ib.slabs[slabNo][slabOffset] = offset
@@ -37,3 +36,47 @@ func (ib *intbank) lookup(sequence uint32) int {
 
 	return ib.slabs[slabNo][slabOffset]
 }
+
+// uint32bank holds a uint32 for each sequence number. Its slabs are allocated
+// when a value is first set, so it takes no memory if it is never used.
+// Values that have not been set are 0.
+type uint32bank struct {
+	slabs [][]uint32
+}
+
+func (b *uint32bank) close() {
+	for _, s := range b.slabs {
+		mmap.Free(s)
+	}
+	b.slabs = nil
+}
+
+// get returns the value for sequence.
+func (b *uint32bank) get(sequence uint32) uint32 {
+	sequence-- // externally sequence starts at 1
+	if slabNo := int(sequence / intbanksize); slabNo < len(b.slabs) {
+		return b.slabs[slabNo][sequence%intbanksize]
+	}
+	return 0
+}
+
+// ref returns a pointer to the value for sequence, so that it can be changed.
+func (b *uint32bank) ref(sequence uint32) *uint32 {
+	sequence--
+	slabNo := int(sequence / intbanksize)
+	for len(b.slabs) <= slabNo {
+		s, err := mmap.Alloc[uint32](intbanksize)
+		if err != nil {
+			panic(err)
+		}
+		b.slabs = append(b.slabs, s)
+	}
+	return &b.slabs[slabNo][sequence%intbanksize]
+}
+
+// move copies the value for sequence from to sequence to.
+func (b *uint32bank) move(from, to uint32) {
+	if v := b.get(from); v != 0 || b.get(to) != 0 {
+		*b.ref(to) = v
+	}
+}
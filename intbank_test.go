@@ -20,3 +20,22 @@ func TestIntbank(t *testing.T) {
 		t.Fatalf("expected 37, got %d", v)
 	}
 }
+
+func TestUint32bank(t *testing.T) {
+	var b uint32bank
+	defer b.close()
+
+	if v := b.get(1); v != 0 {
+		t.Fatalf("expected 0, got %d", v)
+	}
+	*b.ref(intbanksize + 2) = 37
+	if v := b.get(intbanksize + 2); v != 37 {
+		t.Fatalf("expected 37, got %d", v)
+	}
+	if v := b.get(1); v != 0 {
+		t.Fatalf("expected 0, got %d", v)
+	}
+	if v := b.get(3 * intbanksize); v != 0 {
+		t.Fatalf("expected 0, got %d", v)
+	}
+}
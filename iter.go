@@ -0,0 +1,42 @@
+package swisssymbols
+
+import (
+	"iter"
+	"math"
+)
+
+// All returns an iterator over the strings in the SymbolTab and their
+// sequence numbers, in sequence number order. Deleted strings are skipped. See
+// Range.
+func (m *SymbolTab) All() iter.Seq2[uint32, string] {
+	return m.Range(1, math.MaxUint32)
+}
+
+// Range returns an iterator over the strings with sequence numbers from from
+// to to inclusive, and their sequence numbers, in sequence number order. The
+// range is reduced to the strings in the SymbolTab when iteration starts, so
+// strings added during iteration are not included.
+//
+// The iterator reads the strings directly from where they are stored, so it
+// uses no extra memory however many strings there are. As with
+// SequenceToString, the strings are only valid until the SymbolTab is closed.
+func (m *SymbolTab) Range(from, to uint32) iter.Seq2[uint32, string] {
+	return func(yield func(uint32, string) bool) {
+		to := min(to, uint32(m.count))
+		for seq := max(from, 1); seq <= to; {
+			// We walk through each intbank slab in turn rather than looking
+			// up each sequence number.
+			slab := m.ib.slabs[(seq-1)/intbanksize]
+			for _, offset := range slab[(seq-1)%intbanksize:] {
+				// Deleted strings have negative offsets.
+				if offset >= 0 && !yield(seq, m.sb.Get(offset)) {
+					return
+				}
+				if seq == to {
+					return
+				}
+				seq++
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package swisssymbols
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestAll(t *testing.T) {
+	st := New()
+	defer st.Close()
+	for range st.All() {
+		t.Fatal("empty SymbolTab has strings")
+	}
+
+	const n = 3*intbanksize + 10
+	for i := range n {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	expect := uint32(1)
+	for seq, val := range st.All() {
+		if seq != expect || val != strconv.Itoa(int(seq)-1) {
+			t.Fatalf("expected seq %d, got %d %q", expect, seq, val)
+		}
+		if seq == 10 {
+			// Strings added during iteration are not included.
+			st.StringToSequence("new", true)
+		}
+		expect++
+	}
+	if expect != n+1 {
+		t.Fatalf("iterated over %d strings, expected %d", expect-1, n)
+	}
+}
+
+func TestRange(t *testing.T) {
+	st := New()
+	defer st.Close()
+	const n = 3*intbanksize + 10
+	for i := range n {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+
+	tests := []struct {
+		name       string
+		from, to   uint32
+		start, end uint32
+	}{
+		{name: "one", from: 7, to: 7, start: 7, end: 7},
+		{name: "from zero", from: 0, to: 5, start: 1, end: 5},
+		{name: "across slabs", from: intbanksize - 2, to: 2*intbanksize + 3, start: intbanksize - 2, end: 2*intbanksize + 3},
+		{name: "past the end", from: n - 3, to: n + 100, start: n - 3, end: n},
+		{name: "empty", from: 10, to: 9, start: 10, end: 9},
+		{name: "beyond", from: n + 1, to: n + 5, start: n + 1, end: n},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expect := test.start
+			for seq, val := range st.Range(test.from, test.to) {
+				if seq != expect || val != strconv.Itoa(int(seq)-1) {
+					t.Fatalf("expected seq %d, got %d %q", expect, seq, val)
+				}
+				expect++
+			}
+			if expect != test.end+1 {
+				t.Fatalf("iteration ended at %d, expected %d", expect-1, test.end)
+			}
+		})
+	}
+
+	// Stopping early
+	var count int
+	for seq := range st.Range(1, n) {
+		count++
+		if seq == 100 {
+			break
+		}
+	}
+	if count != 100 {
+		t.Fatalf("expected 100 strings before stopping, got %d", count)
+	}
+}
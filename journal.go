@@ -0,0 +1,362 @@
+package swisssymbols
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// A journal file is
+//
+//	magic   [4]byte "SSJL"
+//	version uint32
+//	records
+//
+// Each record is
+//
+//	hdrsum   uint32 CRC-32C of seq and length
+//	seq      uint32
+//	length   uint32
+//	checksum uint32 CRC-32C of the string
+//	string   length bytes
+//
+// All integers are little-endian. Records are in sequence order with no gaps.
+// A crash can leave a partial record at the end of the file. This is ignored
+// when the journal is read, and removed when it is reopened. A bad record with
+// more records after it is not the result of a crash, and reading the journal
+// fails. The length has its own checksum so that we can tell where a bad
+// record ends.
+const (
+	journalMagic      = "SSJL"
+	journalVersion    = 1
+	journalHeaderSize = 8
+	journalRecordSize = 16
+)
+
+// SyncPolicy controls how often a Journal asks the OS to flush records to
+// stable storage. Every record is written to the OS as soon as its sequence
+// number is assigned, so records always survive a crash of the process. The
+// SyncPolicy decides which records survive a crash of the machine.
+//
+// A positive SyncPolicy is the longest time a record waits before it is
+// flushed.
+type SyncPolicy time.Duration
+
+const (
+	// SyncAlways flushes each record before its sequence number is returned.
+	// It is the safest policy, and much the slowest.
+	SyncAlways SyncPolicy = 0
+	// SyncNever only flushes records when Sync or Close is called.
+	SyncNever SyncPolicy = -1
+)
+
+// Journal is a write-ahead log of the strings added to a SymbolTab. Attach it
+// with SetJournal. Use Recover or Replay to load the journal after a crash.
+type Journal struct {
+	f    *os.File
+	last uint32
+	buf  []byte
+
+	policy SyncPolicy
+	stop   chan struct{}
+	done   chan struct{}
+
+	mu    sync.Mutex
+	dirty bool
+	err   error
+}
+
+// OpenJournal opens the journal file at path, creating it if needed. New
+// records are appended to any already in the file.
+func OpenJournal(path string, policy SyncPolicy) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal{
+		f:      f,
+		policy: policy,
+	}
+	if err := j.open(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("opening journal %s: %w", path, err)
+	}
+
+	if policy > 0 {
+		j.stop = make(chan struct{})
+		j.done = make(chan struct{})
+		go j.syncLoop()
+	}
+	return j, nil
+}
+
+// open checks the records in an existing journal and removes any partial
+// record at the end, or writes the header if the journal is new.
+func (j *Journal) open() error {
+	fi, err := j.f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() == 0 {
+		var hdr [journalHeaderSize]byte
+		copy(hdr[:], journalMagic)
+		binary.LittleEndian.PutUint32(hdr[4:], journalVersion)
+		if _, err := j.f.Write(hdr[:]); err != nil {
+			return err
+		}
+		return j.f.Sync()
+	}
+
+	end, err := readJournal(j.f, func(seq uint32, val []byte) error {
+		j.last = seq
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if end != fi.Size() {
+		if err := j.f.Truncate(end); err != nil {
+			return err
+		}
+	}
+	_, err = j.f.Seek(end, io.SeekStart)
+	return err
+}
+
+// SetJournal attaches a journal to the SymbolTab. Each new string is recorded
+// in the journal as its sequence number is assigned. If the journal is empty,
+// any strings already in the SymbolTab are written to it first. Otherwise the
+// journal must hold exactly as many strings as the SymbolTab, as it does after
+// the journal is replayed into the SymbolTab. Pass nil to detach the journal.
+//
+// If writing to the journal fails, StringToSequence panics rather than return a
+// sequence number that would not survive a crash.
+//
+// A journal can't record deletions or reused sequence numbers, so SetJournal
+// returns ErrHasDeletions if strings have been deleted, and an error if the
+// SymbolTab was created WithSequenceReuse.
+func (m *SymbolTab) SetJournal(j *Journal) error {
+	if j != nil {
+		if m.deleted > 0 {
+			return ErrHasDeletions
+		}
+		if m.reuseSeqs {
+			return errors.New("swisssymbols: a journal can't be used with WithSequenceReuse")
+		}
+		if j.last != 0 && int(j.last) != m.count {
+			return fmt.Errorf("journal has %d strings but the symbol table has %d. Replay the journal first", j.last, m.count)
+		}
+		for seq := j.last + 1; seq <= uint32(m.count); seq++ {
+			if err := j.append(seq, m.SequenceToString(seq)); err != nil {
+				return err
+			}
+		}
+	}
+	m.journal = j
+	return nil
+}
+
+// Recover creates a new SymbolTab and replays the journal at path into it.
+func Recover(path string) (*SymbolTab, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := New()
+	if err := m.Replay(f); err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// Replay adds the strings recorded in a journal to the SymbolTab, giving each
+// the sequence number it has in the journal. Records for strings the SymbolTab
+// already holds are checked against it. This means a journal can be replayed
+// on top of a snapshot taken while the journal was being written.
+func (m *SymbolTab) Replay(r io.Reader) error {
+	if m.frozen {
+		return ErrReadOnly
+	}
+	defer m.pauseReuse()()
+	_, err := readJournal(r, func(seq uint32, val []byte) error {
+		if int(seq) <= m.count {
+			if m.SequenceToString(seq) != string(val) {
+				return fmt.Errorf("journal string %d does not match the symbol table", seq)
+			}
+			return nil
+		}
+		if int(seq) != m.count+1 {
+			return fmt.Errorf("journal skips from sequence %d to %d", m.count, seq)
+		}
+		if _, found := m.StringToSequence(unsafe.String(unsafe.SliceData(val), len(val)), true); found {
+			return fmt.Errorf("journal string %d is a duplicate", seq)
+		}
+		return nil
+	})
+	return err
+}
+
+// readJournal calls fn for each record in the journal. It stops without error
+// at an incomplete or corrupt record at the very end of the journal, which is
+// presumably the result of a crash. A corrupt record followed by more data is
+// an error. It returns the offset of the end of the last good record.
+func readJournal(r io.Reader, fn func(seq uint32, val []byte) error) (end int64, err error) {
+	br := bufio.NewReader(r)
+	var hdr [journalHeaderSize]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return 0, fmt.Errorf("reading journal header: %w", err)
+	}
+	if string(hdr[:4]) != journalMagic {
+		return 0, errors.New("not a swisssymbols journal")
+	}
+	if v := binary.LittleEndian.Uint32(hdr[4:]); v != journalVersion {
+		return 0, fmt.Errorf("unsupported journal version %d", v)
+	}
+
+	end = journalHeaderSize
+	var last uint32
+	var rec [journalRecordSize]byte
+	var buf []byte
+	for {
+		if _, err := io.ReadFull(br, rec[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return end, nil
+			}
+			return end, err
+		}
+		if crc32.Checksum(rec[4:12], crcTable) != binary.LittleEndian.Uint32(rec[:]) {
+			return end, journalTail(br, end, "has bad header")
+		}
+		seq := binary.LittleEndian.Uint32(rec[4:])
+		l := binary.LittleEndian.Uint32(rec[8:])
+		if l > maxStringLen {
+			return end, fmt.Errorf("journal record at offset %d has bad length %d", end, l)
+		}
+		if buf, err = appendFrom(br, buf[:0], uint64(l)); err != nil {
+			// The header is good, so a short string can only be a torn
+			// write at the end of the journal.
+			if err == io.ErrUnexpectedEOF {
+				return end, nil
+			}
+			return end, err
+		}
+		if crc32.Checksum(buf, crcTable) != binary.LittleEndian.Uint32(rec[12:]) {
+			return end, journalTail(br, end, "has bad checksum")
+		}
+		if last != 0 && seq != last+1 {
+			return end, fmt.Errorf("journal skips from sequence %d to %d", last, seq)
+		}
+		if err := fn(seq, buf); err != nil {
+			return end, err
+		}
+		last = seq
+		end += journalRecordSize + int64(l)
+	}
+}
+
+// journalTail is called when the record at offset end is bad. If there is
+// nothing after the record it is a torn write at the end of the journal, and
+// journalTail returns nil. Otherwise the journal is corrupt.
+func journalTail(br *bufio.Reader, end int64, problem string) error {
+	if _, err := br.Peek(1); err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	return fmt.Errorf("journal record at offset %d %s and is followed by more data", end, problem)
+}
+
+// append writes a record to the journal.
+func (j *Journal) append(seq uint32, val string) error {
+	if j.last != 0 && seq != j.last+1 {
+		return fmt.Errorf("journal record %d does not follow %d", seq, j.last)
+	}
+	need := journalRecordSize + len(val)
+	if cap(j.buf) < need {
+		j.buf = make([]byte, need)
+	}
+	j.buf = j.buf[:need]
+	binary.LittleEndian.PutUint32(j.buf[4:], seq)
+	binary.LittleEndian.PutUint32(j.buf[8:], uint32(len(val)))
+	copy(j.buf[journalRecordSize:], val)
+	binary.LittleEndian.PutUint32(j.buf, crc32.Checksum(j.buf[4:12], crcTable))
+	binary.LittleEndian.PutUint32(j.buf[12:], crc32.Checksum(j.buf[journalRecordSize:], crcTable))
+
+	if _, err := j.f.Write(j.buf); err != nil {
+		return err
+	}
+	j.last = seq
+
+	switch {
+	case j.policy == SyncAlways:
+		return j.f.Sync()
+	case j.policy > 0:
+		j.mu.Lock()
+		j.dirty = true
+		j.mu.Unlock()
+	}
+	return nil
+}
+
+// syncLoop flushes the journal periodically
+func (j *Journal) syncLoop() {
+	defer close(j.done)
+	ticker := time.NewTicker(time.Duration(j.policy))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.stop:
+			return
+		case <-ticker.C:
+			j.mu.Lock()
+			dirty := j.dirty
+			j.dirty = false
+			j.mu.Unlock()
+			if dirty {
+				if err := j.f.Sync(); err != nil {
+					j.mu.Lock()
+					if j.err == nil {
+						j.err = err
+					}
+					j.mu.Unlock()
+				}
+			}
+		}
+	}
+}
+
+// Sync flushes the journal to stable storage.
+func (j *Journal) Sync() error {
+	if err := j.f.Sync(); err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
+
+// Close flushes the journal and closes the file. Detach the journal from its
+// SymbolTab before closing it.
+func (j *Journal) Close() error {
+	if j.stop != nil {
+		close(j.stop)
+		<-j.done
+	}
+	err := j.Sync()
+	if cerr := j.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,400 @@
+package swisssymbols
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestJournalRecover(t *testing.T) {
+	for _, policy := range []SyncPolicy{SyncAlways, SyncNever, SyncPolicy(time.Millisecond)} {
+		t.Run(time.Duration(policy).String(), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "journal")
+			j, err := OpenJournal(path, policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			st := New()
+			defer st.Close()
+			if err := st.SetJournal(j); err != nil {
+				t.Fatal(err)
+			}
+			for i := range 1000 {
+				st.StringToSequence(strconv.Itoa(i), true)
+			}
+			// Looking up existing strings doesn't write to the journal
+			st.StringToSequence("0", true)
+			if err := j.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			recovered, err := Recover(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer recovered.Close()
+			assertSameSymbols(t, st, recovered)
+		})
+	}
+}
+
+func TestJournalTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	j, err := OpenJournal(path, SyncNever)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := New()
+	defer st.Close()
+	if err := st.SetJournal(j); err != nil {
+		t.Fatal(err)
+	}
+	st.StringToSequence("hat", true)
+	st.StringToSequence("coat", true)
+	st.StringToSequence("scarf", true)
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Chop the last record in half, as a crash might
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data[:len(data)-3], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, err := Recover(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recovered.Len() != 2 {
+		t.Fatalf("expected 2 strings, got %d", recovered.Len())
+	}
+
+	// Reopening the journal removes the partial record, so we can carry on
+	// where we left off.
+	j, err = OpenJournal(path, SyncNever)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recovered.SetJournal(j); err != nil {
+		t.Fatal(err)
+	}
+	if seq, _ := recovered.StringToSequence("gloves", true); seq != 3 {
+		t.Fatalf("expected seq 3, got %d", seq)
+	}
+	recovered.SetJournal(nil)
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	recovered.Close()
+
+	recovered, err = Recover(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.Close()
+	if s := recovered.SequenceToString(3); s != "gloves" {
+		t.Fatalf("expected gloves, got %s", s)
+	}
+}
+
+func TestJournalCorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	j, err := OpenJournal(path, SyncNever)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := New()
+	defer st.Close()
+	if err := st.SetJournal(j); err != nil {
+		t.Fatal(err)
+	}
+	st.StringToSequence("hat", true)
+	st.StringToSequence("coat", true)
+	st.StringToSequence("scarf", true)
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Flip a byte in "coat". This isn't a torn write at the end, as there's a
+	// good record after it.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.Index(data, []byte("coat"))
+	if i < 0 {
+		t.Fatal("coat not found in journal")
+	}
+	data[i+1] ^= 0x20
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Recover(path); err == nil {
+		t.Fatal("expected an error recovering a corrupt journal")
+	}
+	if _, err := OpenJournal(path, SyncNever); err == nil {
+		t.Fatal("expected an error opening a corrupt journal")
+	}
+
+	// Opening the journal must not have truncated it
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after, data) {
+		t.Fatalf("journal changed from %d to %d bytes", len(data), len(after))
+	}
+
+	// The same damage to the last record looks like a torn write
+	data[i+1] ^= 0x20
+	i = bytes.Index(data, []byte("scarf"))
+	data[i+1] ^= 0x20
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	recovered, err := Recover(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.Close()
+	if recovered.Len() != 2 {
+		t.Fatalf("expected 2 strings, got %d", recovered.Len())
+	}
+}
+
+func TestJournalCorruptLength(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	j, err := OpenJournal(path, SyncNever)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := New()
+	defer st.Close()
+	if err := st.SetJournal(j); err != nil {
+		t.Fatal(err)
+	}
+	st.StringToSequence("hat", true)
+	st.StringToSequence("coat", true)
+	st.StringToSequence("scarf", true)
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Make the length of "coat" run past the end of the journal. This must
+	// not be mistaken for a torn write, as that would lose "scarf".
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.Index(data, []byte("coat"))
+	if i < 0 {
+		t.Fatal("coat not found in journal")
+	}
+	binary.LittleEndian.PutUint32(data[i-8:], 200)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Recover(path); err == nil {
+		t.Fatal("expected an error recovering a journal with a bad length")
+	}
+	if _, err := OpenJournal(path, SyncNever); err == nil {
+		t.Fatal("expected an error opening a journal with a bad length")
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after, data) {
+		t.Fatalf("journal changed from %d to %d bytes", len(data), len(after))
+	}
+}
+
+func TestJournalSnapshot(t *testing.T) {
+	// A journal attached to a table that already has strings records those
+	// strings too, and the journal can be replayed over a snapshot.
+	st := New()
+	defer st.Close()
+	st.StringToSequence("hat", true)
+	st.StringToSequence("coat", true)
+
+	path := filepath.Join(t.TempDir(), "journal")
+	j, err := OpenJournal(path, SyncNever)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if err := st.SetJournal(j); err != nil {
+		t.Fatal(err)
+	}
+
+	var snapshot bytes.Buffer
+	if _, err := st.WriteTo(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	st.StringToSequence("scarf", true)
+	if err := j.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	var recovered SymbolTab
+	defer recovered.Close()
+	if _, err := recovered.ReadFrom(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recovered.Replay(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	assertSameSymbols(t, st, &recovered)
+
+	// A journal that's ahead of the table can't be attached
+	empty := New()
+	defer empty.Close()
+	if err := empty.SetJournal(j); err == nil {
+		t.Fatal("expected an error attaching journal to an empty table")
+	}
+
+	// Nor can one that's behind, as we can't tell whether its strings are
+	// the table's.
+	other := New()
+	defer other.Close()
+	for _, val := range []string{"hat", "gloves", "scarf", "boots"} {
+		other.StringToSequence(val, true)
+	}
+	if err := other.SetJournal(j); err == nil {
+		t.Fatal("expected an error attaching journal to a longer table")
+	}
+}
+
+// assertSameSymbols checks that two SymbolTabs hold the same strings with the
+// same sequence numbers
+func assertSameSymbols(t *testing.T, expected, actual *SymbolTab) {
+	t.Helper()
+	if expected.Len() != actual.Len() {
+		t.Fatalf("expected %d strings, got %d", expected.Len(), actual.Len())
+	}
+	for seq := uint32(1); seq <= uint32(expected.Len()); seq++ {
+		val := expected.SequenceToString(seq)
+		if s := actual.SequenceToString(seq); s != val {
+			t.Fatalf("expected %q for seq %d, got %q", val, seq, s)
+		}
+		if s, found := actual.StringToSequence(val, false); !found || s != seq {
+			t.Fatalf("expected seq %d for %q, got %d (found=%t)", seq, val, s, found)
+		}
+	}
+}
+
+func TestJournalFailedLoad(t *testing.T) {
+	src := New()
+	defer src.Close()
+	src.StringToSequence("hat", true)
+	src.StringToSequence("coat", true)
+	snapshot, err := src.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot[len(snapshot)-1] ^= 0xFF
+
+	path := filepath.Join(t.TempDir(), "journal")
+	j, err := OpenJournal(path, SyncNever)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := New()
+	defer st.Close()
+	if err := st.SetJournal(j); err != nil {
+		t.Fatal(err)
+	}
+
+	// The failed load doesn't reach the journal, and the journal is still
+	// attached afterwards.
+	if err := st.UnmarshalBinary(snapshot); err == nil {
+		t.Fatal("expected an error loading a corrupt snapshot")
+	}
+	st.StringToSequence("scarf", true)
+	st.SetJournal(nil)
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, err := Recover(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.Close()
+	assertSameSymbols(t, st, recovered)
+	if recovered.Len() != 1 {
+		t.Fatalf("expected 1 string, have %d", recovered.Len())
+	}
+}
+
+func TestJournalHugeTornRecord(t *testing.T) {
+	// A torn record whose length claims far more data than the journal
+	// holds must not make us allocate for that length.
+	path := filepath.Join(t.TempDir(), "journal")
+	j, err := OpenJournal(path, SyncNever)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := New()
+	defer st.Close()
+	if err := st.SetJournal(j); err != nil {
+		t.Fatal(err)
+	}
+	st.StringToSequence("hat", true)
+	st.SetJournal(nil)
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hdr [8]byte
+	binary.LittleEndian.PutUint32(hdr[:], 2)
+	binary.LittleEndian.PutUint32(hdr[4:], maxStringLen)
+	data = binary.LittleEndian.AppendUint32(data, crc32.Checksum(hdr[:], crcTable))
+	data = append(data, hdr[:]...)
+	data = binary.LittleEndian.AppendUint32(data, 0)
+	data = append(data, "coa"...)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	recovered, err := Recover(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.Close()
+	j, err = OpenJournal(path, SyncNever)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	runtime.ReadMemStats(&after)
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+		t.Fatalf("allocated %d bytes reading a %d byte journal", alloc, len(data))
+	}
+	if recovered.Len() != 1 {
+		t.Fatalf("expected 1 string, have %d", recovered.Len())
+	}
+}
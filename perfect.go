@@ -0,0 +1,275 @@
+package swisssymbols
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"slices"
+)
+
+// A perfect index maps each string in an image to its own slot using a
+// minimal perfect hash, so there are exactly as many slots as strings. We use
+// "hash and displace". Each string's StableHash64 picks a bucket, and each
+// bucket records a displacement chosen so that every string in the bucket
+// lands in a free slot. Buckets with a single string instead record their slot
+// directly.
+//
+// Each slot holds the sequence number of its string and a 16 bit fingerprint
+// taken from the hash. A lookup hashes the string once, reads the bucket's
+// displacement and the slot's fingerprint, and only compares strings if the
+// fingerprint matches. So no lookup compares more than one string, and almost
+// all misses compare none.
+//
+// The index section of an image with a perfect index is
+//
+//	seed  uint64 the seed for StableHash64
+//	disp  [buckets]uint32
+//	seqs  [count]uint32
+//	fps   [count]uint16
+const (
+	// perfectBucketSize is the average number of strings in each bucket.
+	// Bigger buckets save space but take longer to build.
+	perfectBucketSize = 4
+	// perfectDirect marks a displacement that is actually a slot number.
+	perfectDirect = 1 << 31
+	// perfectMaxTries is how many displacements we try for a bucket before
+	// giving up and trying another seed.
+	perfectMaxTries = 1 << 20
+	// perfectMaxSeeds is how many seeds we try before giving up altogether.
+	perfectMaxSeeds = 100
+)
+
+// WritePerfectImage writes an image of the SymbolTab to w, like WriteImage,
+// but indexes it with a minimal perfect hash. The image takes longer to
+// build, but uses less memory and answers lookups for missing strings faster.
+// LoadImage loads either kind of image.
+func (m *SymbolTab) WritePerfectImage(w io.Writer) (n int64, err error) {
+	return m.writeImage(w, imageIndexPerfect)
+}
+
+// PerfectImage builds an Image of the SymbolTab indexed with a minimal perfect
+// hash. The Image does not depend on the SymbolTab, which can be closed.
+func (m *SymbolTab) PerfectImage() (*Image, error) {
+	var buf bytes.Buffer
+	if _, err := m.WritePerfectImage(&buf); err != nil {
+		return nil, err
+	}
+	return LoadImage(buf.Bytes())
+}
+
+func perfectBuckets(count uint32) uint32 {
+	return max(1, (count+perfectBucketSize-1)/perfectBucketSize)
+}
+
+func perfectIndexLen(count, buckets uint32) uint64 {
+	return 8 + uint64(buckets)*4 + uint64(count)*6
+}
+
+// perfectBucket returns the bucket for a string with hash h
+func perfectBucket(h uint64, buckets uint32) uint32 {
+	hi, _ := bits.Mul64(h, uint64(buckets))
+	return uint32(hi)
+}
+
+// perfectSlot returns the slot for a string with hash h in a bucket with
+// displacement d.
+func perfectSlot(h uint64, d uint32, count uint32) uint32 {
+	if d&perfectDirect != 0 {
+		return d &^ perfectDirect
+	}
+	hi, _ := bits.Mul64(wymix(h^wyp2, uint64(d)^wyp3), uint64(count))
+	return uint32(hi)
+}
+
+// perfectIndex builds a perfect index for the strings in the SymbolTab. It
+// returns the number of buckets and the index section of the image.
+func (m *SymbolTab) perfectIndex() (buckets uint32, index []byte, err error) {
+	count := uint32(m.count)
+	if count >= perfectDirect {
+		return 0, nil, errors.New("too many strings for a perfect index")
+	}
+	buckets = perfectBuckets(count)
+	b := perfectBuilder{
+		m:       m,
+		count:   count,
+		buckets: buckets,
+		hashes:  make([]uint64, count),
+		disp:    make([]uint32, buckets),
+		seqs:    make([]uint32, count),
+		taken:   make([]uint64, (count+63)/64),
+	}
+	for seed := range uint64(perfectMaxSeeds) {
+		if b.build(seed) {
+			return buckets, b.encode(seed), nil
+		}
+	}
+	return 0, nil, errors.New("could not build a perfect index")
+}
+
+type perfectBuilder struct {
+	m       *SymbolTab
+	count   uint32
+	buckets uint32
+	hashes  []uint64
+	disp    []uint32
+	seqs    []uint32
+	taken   []uint64
+}
+
+// build tries to build the index using seed. It returns false if it can't,
+// in which case we should try another seed.
+func (b *perfectBuilder) build(seed uint64) bool {
+	clear(b.disp)
+	clear(b.seqs)
+	clear(b.taken)
+
+	// Group the strings by bucket. start[i] is where bucket i's strings start
+	// in members.
+	start := make([]uint32, b.buckets+1)
+	for i := range b.count {
+		h := StableHash64(b.m.SequenceToString(i+1), seed)
+		b.hashes[i] = h
+		start[perfectBucket(h, b.buckets)+1]++
+	}
+	var maxSize uint32
+	for i := range b.buckets {
+		maxSize = max(maxSize, start[i+1])
+		start[i+1] += start[i]
+	}
+	members := make([]uint32, b.count)
+	fill := slices.Clone(start[:b.buckets])
+	for i := range b.count {
+		bucket := perfectBucket(b.hashes[i], b.buckets)
+		members[fill[bucket]] = i
+		fill[bucket]++
+	}
+
+	// Place the biggest buckets first, while there are plenty of free slots.
+	// Buckets with one string go last, as we can put them anywhere.
+	bySize := make([][]uint32, maxSize+1)
+	for i := range b.buckets {
+		size := start[i+1] - start[i]
+		bySize[size] = append(bySize[size], i)
+	}
+	var slots []uint32
+	for size := maxSize; size >= 2; size-- {
+		for _, bucket := range bySize[size] {
+			d, ok := b.displace(members[start[bucket]:start[bucket+1]], &slots)
+			if !ok {
+				return false
+			}
+			b.disp[bucket] = d
+			for j, i := range members[start[bucket]:start[bucket+1]] {
+				b.place(slots[j], i)
+			}
+		}
+	}
+	if maxSize >= 1 {
+		var free uint32
+		for _, bucket := range bySize[1] {
+			for b.taken[free/64]&(1<<(free%64)) != 0 {
+				free++
+			}
+			b.disp[bucket] = free | perfectDirect
+			b.place(free, members[start[bucket]])
+		}
+	}
+	return true
+}
+
+// displace finds a displacement that puts all the strings in a bucket in free
+// slots. The slots are returned in slots.
+func (b *perfectBuilder) displace(members []uint32, slots *[]uint32) (uint32, bool) {
+	for j, i := range members {
+		for _, k := range members[:j] {
+			if b.hashes[i] == b.hashes[k] {
+				// No displacement can separate these.
+				return 0, false
+			}
+		}
+	}
+
+next:
+	for d := range uint32(perfectMaxTries) {
+		*slots = (*slots)[:0]
+		for _, i := range members {
+			s := perfectSlot(b.hashes[i], d, b.count)
+			if b.taken[s/64]&(1<<(s%64)) != 0 {
+				continue next
+			}
+			for _, other := range *slots {
+				if other == s {
+					continue next
+				}
+			}
+			*slots = append(*slots, s)
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+func (b *perfectBuilder) place(slot, i uint32) {
+	b.taken[slot/64] |= 1 << (slot % 64)
+	b.seqs[slot] = i + 1
+}
+
+// encode returns the index section of the image
+func (b *perfectBuilder) encode(seed uint64) []byte {
+	index := make([]byte, 0, perfectIndexLen(b.count, b.buckets))
+	index = binary.LittleEndian.AppendUint64(index, seed)
+	for _, d := range b.disp {
+		index = binary.LittleEndian.AppendUint32(index, d)
+	}
+	for _, seq := range b.seqs {
+		index = binary.LittleEndian.AppendUint32(index, seq)
+	}
+	for _, seq := range b.seqs {
+		index = binary.LittleEndian.AppendUint16(index, uint16(b.hashes[seq-1]))
+	}
+	return index
+}
+
+// loadPerfectIndex sets up the Image to use a perfect index from an image,
+// checking it is consistent so that lookups can't go out of range.
+func (im *Image) loadPerfectIndex(index []byte, buckets uint32) error {
+	im.seed = binary.LittleEndian.Uint64(index)
+	im.buckets = buckets
+	index = index[8:]
+	im.disp, index = index[:buckets*4], index[buckets*4:]
+	im.seqs, im.fps = index[:im.count*4], index[im.count*4:]
+
+	for bucket := range buckets {
+		d := binary.LittleEndian.Uint32(im.disp[bucket*4:])
+		if d&perfectDirect != 0 && d&^perfectDirect >= im.count {
+			return fmt.Errorf("image index bucket %d is out of range", bucket)
+		}
+	}
+	for slot := range im.count {
+		if seq := binary.LittleEndian.Uint32(im.seqs[slot*4:]); seq == 0 || seq > im.count {
+			return fmt.Errorf("image index slot %d is out of range", slot)
+		}
+	}
+	return nil
+}
+
+// perfectLookup returns the sequence number for val, or 0 if it isn't present.
+func (im *Image) perfectLookup(val string) uint32 {
+	if im.count == 0 {
+		return 0
+	}
+	h := StableHash64(val, im.seed)
+	d := binary.LittleEndian.Uint32(im.disp[perfectBucket(h, im.buckets)*4:])
+	slot := perfectSlot(h, d, im.count)
+	if binary.LittleEndian.Uint16(im.fps[slot*2:]) != uint16(h) {
+		return 0
+	}
+	seq := binary.LittleEndian.Uint32(im.seqs[slot*4:])
+	if im.SequenceToString(seq) != val {
+		return 0
+	}
+	return seq
+}
@@ -0,0 +1,71 @@
+package swisssymbols
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+func TestPerfectImage(t *testing.T) {
+	// Enough strings that some buckets are hard to place
+	const n = 300_000
+	st := New()
+	st.StringToSequence("hat", true)
+	for i := range n {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+
+	im, err := st.PerfectImage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := New()
+	defer expected.Close()
+	for seq := uint32(1); seq <= uint32(st.Len()); seq++ {
+		expected.StringToSequence(st.SequenceToString(seq), true)
+	}
+	// The image doesn't need the SymbolTab it was built from
+	st.Close()
+
+	assertImageMatches(t, expected, im)
+	for i := n; i < 2*n; i++ {
+		if seq, found := im.StringToSequence(strconv.Itoa(i), false); found {
+			t.Fatalf("did not expect to find %d, got %d", i, seq)
+		}
+	}
+}
+
+func TestPerfectImageSize(t *testing.T) {
+	st := New()
+	defer st.Close()
+	for i := range 100_000 {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	var probe, perfect bytes.Buffer
+	if _, err := st.WriteImage(&probe); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.WritePerfectImage(&perfect); err != nil {
+		t.Fatal(err)
+	}
+	// The perfect index uses 7 bytes per string. The probe index uses between
+	// 8 and 16.
+	if perfect.Len() >= probe.Len() {
+		t.Fatalf("expected perfect image (%d bytes) to be smaller than probe image (%d bytes)", perfect.Len(), probe.Len())
+	}
+}
+
+func BenchmarkWritePerfectImage(b *testing.B) {
+	st := New()
+	defer st.Close()
+	for i := range 1_000_000 {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		var buf bytes.Buffer
+		if _, err := st.WritePerfectImage(&buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
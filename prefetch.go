@@ -0,0 +1,11 @@
+//go:build amd64 || arm64
+
+package swisssymbols
+
+import "unsafe"
+
+// prefetch asks the CPU to start loading the cache line at p. Unlike an
+// ordinary load it doesn't wait for the data to arrive.
+//
+//go:noescape
+func prefetch(p unsafe.Pointer)
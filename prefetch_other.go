@@ -0,0 +1,8 @@
+//go:build !amd64 && !arm64
+
+package swisssymbols
+
+import "unsafe"
+
+// prefetch does nothing on this architecture.
+func prefetch(p unsafe.Pointer) {}
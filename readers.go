@@ -0,0 +1,297 @@
+package swisssymbols
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/philpearl/mmap"
+)
+
+// WithReaders lets Readers look up strings in the SymbolTab from other
+// goroutines while a single goroutine adds strings to it. See NewReader.
+//
+// The writer never waits for readers. Instead, when it splits a table or
+// grows the directory it works on copies, publishes them, and frees the old
+// versions once no reader can still be using them. This makes splitting a
+// little slower.
+func WithReaders() Option {
+	return func(m *SymbolTab) {
+		m.readers = &readerState{}
+		// Epochs start at 1, so that 0 can mean a Reader is idle.
+		m.readers.epoch.Store(1)
+	}
+}
+
+// readerState is the state a SymbolTab shares with its Readers. Readers only
+// see the SymbolTab through the view, which the writer replaces whenever the
+// directory, the intbank slabs or the stringBank chunks change.
+//
+// Memory that has been removed from the view is freed using epoch-based
+// reclamation. Each Reader records the global epoch while it looks at the
+// view. When the writer retires memory it notes the current epoch and moves
+// the global epoch on. The memory can be freed once every Reader is either
+// idle or has recorded a later epoch, as those Readers must have loaded a view
+// that doesn't include it.
+type readerState struct {
+	view  atomic.Pointer[readView]
+	count atomic.Uint32
+	epoch atomic.Uint64
+
+	// mu serialises changes to list. Readers are only added and removed under
+	// mu, but the writer reads list without it.
+	mu   sync.Mutex
+	list atomic.Pointer[[]*Reader]
+
+	// pending holds memory that is about to be removed from the view, and
+	// retired holds memory that has been removed. Only the writer uses them.
+	pending []retiredMem
+	retired []retiredMem
+
+	// feed wakes subscribers when strings are added. See Subscribe.
+	feed feed
+}
+
+// readView is an immutable view of the SymbolTab for Readers. The slices are
+// copies of the SymbolTab's slice headers, so the writer can append to its
+// own slices without disturbing readers.
+type readView struct {
+	tables []*table
+	shift  uint16
+	slabs  [][]int
+	chunks [][]byte
+}
+
+// retiredMem is a directory or a table that readers may still be using.
+type retiredMem struct {
+	epoch  uint64
+	tables []*table
+	table  *table
+}
+
+// publish replaces the view with one that matches the SymbolTab. Any memory
+// that was pending is retired, as no new reader can find it.
+func (rs *readerState) publish(m *SymbolTab) {
+	rs.view.Store(&readView{
+		tables: m.tables,
+		shift:  m.tableIndexShift,
+		slabs:  m.ib.slabs,
+		chunks: m.sb.chunks,
+	})
+	rs.count.Store(uint32(m.count))
+	rs.feed.notify()
+
+	if len(rs.pending) > 0 {
+		epoch := rs.epoch.Add(1) - 1
+		for _, r := range rs.pending {
+			r.epoch = epoch
+			rs.retired = append(rs.retired, r)
+		}
+		rs.pending = rs.pending[:0]
+	}
+	rs.reclaim(m, false)
+}
+
+// insert makes a new entry visible to readers. The entry is at index in group
+// and has already been written, as has its string.
+func (rs *readerState) insert(m *SymbolTab, group *group, index int, groupHash hashValue) {
+	if v := rs.view.Load(); len(v.slabs) != len(m.ib.slabs) || len(v.chunks) != len(m.sb.chunks) {
+		rs.publish(m)
+	}
+
+	c := group.control
+	c.set(index, groupHash)
+	storeControl(&group.control, c)
+	rs.count.Store(uint32(m.count))
+	rs.feed.notify()
+
+	if len(rs.retired) > 0 {
+		rs.reclaim(m, false)
+	}
+}
+
+// reclaim frees retired memory that no reader can still be using. If all is
+// true it frees all retired memory. Only do that if there are no readers.
+func (rs *readerState) reclaim(m *SymbolTab, all bool) {
+	oldest := ^uint64(0)
+	if !all {
+		if list := rs.list.Load(); list != nil {
+			for _, r := range *list {
+				if epoch := r.epoch.Load(); epoch != 0 {
+					oldest = min(oldest, epoch)
+				}
+			}
+		}
+	}
+
+	var i int
+	for i < len(rs.retired) && rs.retired[i].epoch < oldest {
+		r := rs.retired[i]
+		if r.tables != nil {
+			mmap.Free(r.tables)
+		}
+		if r.table != nil {
+			m.freeTable(r.table)
+		}
+		i++
+	}
+	rs.retired = slices.Delete(rs.retired, 0, i)
+}
+
+// copyDirectory gives the SymbolTab a copy of its directory, so that it can
+// change it without disturbing readers. The old directory is retired at the
+// next publish.
+func (m *SymbolTab) copyDirectory() {
+	newTables, err := mmap.Alloc[*table](len(m.tables))
+	if err != nil {
+		panic(err)
+	}
+	copy(newTables, m.tables)
+	m.readers.pending = append(m.readers.pending, retiredMem{tables: m.tables})
+	m.tables = newTables
+}
+
+// loadControl atomically loads a group's control word.
+func loadControl(gc *groupControl) groupControl {
+	v := atomic.LoadUint64((*uint64)(unsafe.Pointer(gc)))
+	return *(*groupControl)(unsafe.Pointer(&v))
+}
+
+// storeControl atomically stores a group's control word.
+func storeControl(gc *groupControl, c groupControl) {
+	atomic.StoreUint64((*uint64)(unsafe.Pointer(gc)), *(*uint64)(unsafe.Pointer(&c)))
+}
+
+// Reader looks up strings in a SymbolTab created with WithReaders while
+// another goroutine adds strings to it. Reader methods never block and never
+// see a partly updated table. Each Reader must only be used by one goroutine
+// at a time, so give each reading goroutine its own.
+type Reader struct {
+	rs *readerState
+	// epoch is the global epoch when the Reader started its current lookup,
+	// or 0 if it isn't looking anything up.
+	epoch  atomic.Uint64
+	hasher Hasher
+	// Pad Readers apart so that they don't share cache lines.
+	_ [48]byte
+}
+
+// NewReader returns a new Reader for the SymbolTab, which must have been
+// created with WithReaders. It may be called from any goroutine. Close the
+// Reader when it is no longer needed.
+//
+// Close, ReadFrom and UnmarshalBinary must not be called on the SymbolTab
+// while any of its Readers are in use.
+func (m *SymbolTab) NewReader() *Reader {
+	rs := m.readers
+	if rs == nil {
+		panic("swisssymbols: NewReader called on a SymbolTab created without WithReaders")
+	}
+	r := &Reader{rs: rs, hasher: m.hasher}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	var list []*Reader
+	if old := rs.list.Load(); old != nil {
+		list = slices.Clone(*old)
+	}
+	list = append(list, r)
+	rs.list.Store(&list)
+	return r
+}
+
+// Close stops the Reader being used.
+func (r *Reader) Close() error {
+	rs := r.rs
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if old := rs.list.Load(); old != nil {
+		list := slices.DeleteFunc(slices.Clone(*old), func(o *Reader) bool { return o == r })
+		rs.list.Store(&list)
+	}
+	return nil
+}
+
+// Len returns the number of strings the Reader can see.
+func (r *Reader) Len() int {
+	return int(r.rs.count.Load())
+}
+
+// SequenceToString looks up a string by its sequence number. It returns an
+// empty string if there is no such string.
+func (r *Reader) SequenceToString(seq uint32) string {
+	// Strings and the intbank slabs are never freed while the SymbolTab is
+	// open, so we don't need to record an epoch here.
+	if seq == 0 || seq > r.rs.count.Load() {
+		return ""
+	}
+	s, _ := r.rs.view.Load().get(seq)
+	return s
+}
+
+// StringToSequence looks up the string val and returns its sequence number.
+// found indicates whether val is present. A Reader can't add strings, so if
+// val is not present and addNew is true StringToSequence panics with
+// ErrReadOnly.
+func (r *Reader) StringToSequence(val string, addNew bool) (seq uint32, found bool) {
+	hash := r.hasher.hash(val)
+
+	r.epoch.Store(r.rs.epoch.Load())
+	seq = r.rs.view.Load().lookup(hash, val, r.rs.view.Load)
+	r.epoch.Store(0)
+
+	if seq == 0 && addNew {
+		panic(ErrReadOnly)
+	}
+	return seq, seq != 0
+}
+
+// lookup finds val in the tables in the view. If an entry in the tables is
+// for a string that isn't in the view, newer is called to get a more recent
+// view that has it.
+func (v *readView) lookup(hash hashValue, val string, newer func() *readView) uint32 {
+	t := v.tables[hash>>hashValue(v.shift)]
+	groupHash := hash & 0x7F
+	for probe := makeProbeSeq(hash>>7, tableMask); ; probe = probe.next() {
+		group := t.groups.getGroup(probe.offset)
+		control := loadControl(&group.control)
+		matches := control.findMatches(groupHash)
+		for matches != 0 {
+			index := matches.firstSet()
+			// This horrendous line gets the entry at index without doing a bounds check or nil check
+			ent := (*entry)(unsafe.Add(unsafe.Pointer(&group.entries), uintptr(index)*unsafe.Sizeof(entry{})))
+			if ent.hash == hash {
+				s, ok := v.get(ent.seq)
+				if !ok {
+					// The entry was added after we loaded the view, and
+					// its string isn't in the view. A newer view has it.
+					s, _ = newer().get(ent.seq)
+				}
+				if s == val {
+					return ent.seq
+				}
+			}
+			matches = matches.clearFirstBit()
+		}
+		if control.findEmpty() != 0 {
+			return 0
+		}
+	}
+}
+
+// get returns the string for seq. ok is false if the string is not in the
+// view.
+func (v *readView) get(seq uint32) (s string, ok bool) {
+	seq-- // externally sequence starts at 1
+	slabNo := int(seq / intbanksize)
+	if slabNo >= len(v.slabs) {
+		return "", false
+	}
+	offset := v.slabs[slabNo][seq%intbanksize]
+	if offset/stringbankSize >= len(v.chunks) {
+		return "", false
+	}
+	sb := stringBank{chunks: v.chunks}
+	return sb.Get(offset), true
+}
@@ -0,0 +1,153 @@
+package swisssymbols
+
+import (
+	"math/rand/v2"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReaders(t *testing.T) {
+	for _, hasher := range []Hasher{RuntimeHasher, StableHasher} {
+		t.Run(hasher.String(), func(t *testing.T) {
+			st := New(WithHasher(hasher), WithReaders())
+			defer st.Close()
+			testReaders(t, st, 300_000)
+		})
+	}
+}
+
+func TestReadersFile(t *testing.T) {
+	st, err := OpenFile(filepath.Join(t.TempDir(), "symbols"), WithReaders())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	testReaders(t, st, 100_000)
+}
+
+// testReaders adds count strings to st while several Readers look them up.
+func testReaders(t *testing.T, st *SymbolTab, count int) {
+	// added is the number of strings the writer has finished adding.
+	var added atomic.Int64
+	var done atomic.Bool
+	var wg sync.WaitGroup
+	for range 4 {
+		r := st.NewReader()
+		wg.Go(func() {
+			defer r.Close()
+			rnd := rand.New(rand.NewPCG(1, 2))
+			for !done.Load() {
+				n := added.Load()
+				if n == 0 {
+					continue
+				}
+				i := rnd.Int64N(n)
+				val := strconv.FormatInt(i, 10)
+				seq, found := r.StringToSequence(val, false)
+				if !found || seq != uint32(i+1) {
+					t.Errorf("looking up %q gave %d, %t", val, seq, found)
+					return
+				}
+				if got := r.SequenceToString(seq); got != val {
+					t.Errorf("seq %d gives %q, expected %q", seq, got, val)
+					return
+				}
+				if r.Len() < int(n) {
+					t.Errorf("reader has %d strings, expected at least %d", r.Len(), n)
+					return
+				}
+				if seq, found := r.StringToSequence("missing", false); found {
+					t.Errorf("found missing string as %d", seq)
+					return
+				}
+			}
+		})
+	}
+
+	for i := range count {
+		st.StringToSequence(strconv.Itoa(i), true)
+		added.Store(int64(i + 1))
+	}
+	done.Store(true)
+	wg.Wait()
+
+	r := st.NewReader()
+	defer r.Close()
+	if r.Len() != count {
+		t.Fatalf("reader has %d strings, expected %d", r.Len(), count)
+	}
+	if got := r.SequenceToString(uint32(count + 1)); got != "" {
+		t.Fatalf("expected nothing past the end, got %q", got)
+	}
+}
+
+func TestReaderReclaim(t *testing.T) {
+	st := New(WithReaders())
+	defer st.Close()
+	r := st.NewReader()
+	defer r.Close()
+
+	// Pretend the reader is part way through a lookup while the tables split.
+	r.epoch.Store(st.readers.epoch.Load())
+	var i int
+	for ; len(st.readers.retired) == 0; i++ {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	st.StringToSequence(strconv.Itoa(i), true)
+	if len(st.readers.retired) == 0 {
+		t.Fatal("retired memory was freed while a reader could be using it")
+	}
+
+	// Once the reader finishes, the next insert frees it.
+	r.epoch.Store(0)
+	st.StringToSequence("another", true)
+	if len(st.readers.retired) != 0 {
+		t.Fatalf("%d retired items were not freed", len(st.readers.retired))
+	}
+
+	// Lookups still work after the memory is reused.
+	for j := range i {
+		if seq, found := r.StringToSequence(strconv.Itoa(j), false); !found || seq != uint32(j+1) {
+			t.Fatalf("looking up %d gave %d, %t", j, seq, found)
+		}
+	}
+}
+
+func TestReaderAddNew(t *testing.T) {
+	st := New(WithReaders())
+	defer st.Close()
+	r := st.NewReader()
+	defer r.Close()
+
+	defer func() {
+		if p := recover(); p != ErrReadOnly {
+			t.Fatalf("expected ErrReadOnly panic, got %v", p)
+		}
+	}()
+	r.StringToSequence("new", true)
+}
+
+func BenchmarkReader(b *testing.B) {
+	const count = 1 << 20
+	st := New(WithReaders())
+	defer st.Close()
+	vals := make([]string, count)
+	for i := range vals {
+		vals[i] = strconv.Itoa(i)
+		st.StringToSequence(vals[i], true)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := st.NewReader()
+		defer r.Close()
+		var i int
+		for pb.Next() {
+			r.StringToSequence(vals[i%count], false)
+			i += 7
+		}
+	})
+}
@@ -0,0 +1,39 @@
+package swisssymbols
+
+// Acquire looks up val, adding it to the SymbolTab if it is not present, and
+// records that the caller holds it. It returns the sequence number of val.
+//
+// A string that is held is not removed by Delete, DeleteSequence or
+// AdvanceGeneration. Each call to Acquire must be matched by a call to Release
+// once the caller no longer needs the string, and the string is deleted when
+// the last holder releases it. So the sequence number can't be given to
+// another string while anyone holds it, even WithSequenceReuse.
+//
+// Acquire and Release delete strings, so they panic in the same cases as
+// Delete.
+func (m *SymbolTab) Acquire(val string) (seq uint32) {
+	m.checkDelete()
+	seq, _ = m.StringToSequence(val, true)
+	*m.refs.ref(seq)++
+	return seq
+}
+
+// Release records that the caller no longer holds the string with sequence
+// number seq, which it got from Acquire. If there are no other holders the
+// string is deleted. Release panics if the string is not held.
+func (m *SymbolTab) Release(seq uint32) {
+	m.checkDelete()
+	if m.refs.get(seq) == 0 {
+		panic("swisssymbols: Release called for a sequence number that is not held")
+	}
+	refs := m.refs.ref(seq)
+	if *refs--; *refs == 0 {
+		m.deleteSequence(seq)
+	}
+}
+
+// Holders returns the number of holders of the string with sequence number
+// seq. See Acquire.
+func (m *SymbolTab) Holders(seq uint32) int {
+	return int(m.refs.get(seq))
+}
@@ -0,0 +1,112 @@
+package swisssymbols
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestAcquireRelease(t *testing.T) {
+	st := New(WithSequenceReuse())
+	defer st.Close()
+
+	st.StringToSequence("a", true)
+	seq := st.Acquire("b")
+	if seq2 := st.Acquire("b"); seq2 != seq || seq != 2 {
+		t.Fatalf("acquired b as %d and %d", seq, seq2)
+	}
+	if h := st.Holders(seq); h != 2 {
+		t.Fatalf("expected 2 holders, have %d", h)
+	}
+
+	// Held strings can't be deleted.
+	if _, deleted := st.Delete("b"); deleted {
+		t.Fatal("deleted a held string")
+	}
+	if st.DeleteSequence(seq) {
+		t.Fatal("deleted a held sequence number")
+	}
+
+	st.Release(seq)
+	if s := st.SequenceToString(seq); s != "b" {
+		t.Fatalf("b released once gives %q", s)
+	}
+	st.Release(seq)
+	if _, found := st.StringToSequence("b", false); found || st.Len() != 1 {
+		t.Fatalf("b not deleted when released. Len is %d", st.Len())
+	}
+
+	// The sequence number is free to be used again.
+	if seq2 := st.Acquire("c"); seq2 != seq {
+		t.Fatalf("expected c to reuse %d, got %d", seq, seq2)
+	}
+
+	// Acquiring a string that is already present holds it too.
+	if seq := st.Acquire("a"); seq != 1 || st.Holders(1) != 1 {
+		t.Fatalf("acquired a as %d with %d holders", seq, st.Holders(1))
+	}
+
+	func() {
+		defer func() {
+			if p := recover(); p == nil {
+				t.Fatal("expected a panic")
+			}
+		}()
+		st.Release(4)
+	}()
+}
+
+func TestAcquireNotReused(t *testing.T) {
+	st := New(WithSequenceReuse())
+	defer st.Close()
+
+	// Hold every tenth string, and delete the rest.
+	const n = 10_000
+	for i := range n {
+		val := strconv.Itoa(i)
+		if i%10 == 0 {
+			st.Acquire(val)
+		} else {
+			st.StringToSequence(val, true)
+		}
+	}
+	for i := range n {
+		st.Delete(strconv.Itoa(i))
+	}
+	if st.Len() != n/10 {
+		t.Fatalf("expected %d strings, have %d", n/10, st.Len())
+	}
+
+	// New strings only get the sequence numbers that were freed.
+	for i := range n - n/10 {
+		seq, _ := st.StringToSequence("x"+strconv.Itoa(i), true)
+		if seq%10 == 1 || seq > n {
+			t.Fatalf("new string got sequence number %d", seq)
+		}
+	}
+	for i := 0; i < n; i += 10 {
+		if s := st.SequenceToString(uint32(i + 1)); s != strconv.Itoa(i) {
+			t.Fatalf("held sequence %d gives %q", i+1, s)
+		}
+	}
+}
+
+func TestAcquireGenerations(t *testing.T) {
+	st := New(WithGenerations(1))
+	defer st.Close()
+
+	held := st.Acquire("held")
+	st.StringToSequence("other", true)
+	st.AdvanceGeneration(nil)
+	if n := st.AdvanceGeneration(nil); n != 1 {
+		t.Fatalf("expected 1 string evicted, got %d", n)
+	}
+	if s := st.SequenceToString(held); s != "held" {
+		t.Fatalf("held string evicted. Have %q", s)
+	}
+
+	// Once released it goes straight away.
+	st.Release(held)
+	if st.Len() != 0 {
+		t.Fatalf("expected no strings, have %d", st.Len())
+	}
+}
@@ -0,0 +1,195 @@
+package swisssymbols
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"unsafe"
+)
+
+// A replication log is
+//
+//	magic   [4]byte "SSRL"
+//	version uint32
+//	first   uint32 sequence number of the first record
+//	records
+//
+// Each record is a string, written as a uvarint length followed by the bytes.
+// Records are in sequence order with no gaps, so the sequence numbers are not
+// written. Integers in the header are little-endian. The log ends when the
+// stream ends, which may only happen between records.
+const (
+	replicationMagic      = "SSRL"
+	replicationVersion    = 1
+	replicationHeaderSize = 12
+)
+
+// Ship writes a replication log of the strings added to the SymbolTab after
+// sequence number after to w. Once it has caught up it waits for new strings,
+// so it only returns when ctx is done or writing to w fails. A Follower reads
+// the log with Apply. To resume shipping to a Follower, pass its Applied
+// watermark as after.
+//
+// As with Subscribe, the SymbolTab must have been created with WithReaders,
+// Ship reads the SymbolTab through its own Reader, and it may be called from
+// any goroutine. Records are buffered while Ship catches up, and written to w
+// before it waits.
+func (m *SymbolTab) Ship(ctx context.Context, w io.Writer, after uint32) error {
+	if m.readers == nil {
+		panic("swisssymbols: Ship called on a SymbolTab created without WithReaders")
+	}
+	r := m.NewReader()
+	defer r.Close()
+
+	bw := bufio.NewWriter(w)
+	var hdr [replicationHeaderSize]byte
+	copy(hdr[:], replicationMagic)
+	binary.LittleEndian.PutUint32(hdr[4:], replicationVersion)
+	binary.LittleEndian.PutUint32(hdr[8:], after+1)
+	if _, err := bw.Write(hdr[:]); err != nil {
+		return err
+	}
+
+	var lenBuf [binary.MaxVarintLen64]byte
+	for seq := after + 1; ; seq++ {
+		if uint32(r.Len()) < seq {
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+			if !r.rs.feed.wait(ctx, r.rs, seq) {
+				return ctx.Err()
+			}
+		}
+		val := r.SequenceToString(seq)
+		if _, err := bw.Write(lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(val)))]); err != nil {
+			return err
+		}
+		if _, err := bw.WriteString(val); err != nil {
+			return err
+		}
+	}
+}
+
+// Follower keeps a copy of a leader SymbolTab up to date by applying the
+// replication log written by the leader's Ship method. It serves read-only
+// lookups through Readers and Views, which always agree with the leader for
+// the strings applied so far.
+type Follower struct {
+	m *SymbolTab
+	// mu stops more than one log being applied at a time.
+	mu sync.Mutex
+}
+
+// NewFollower creates a Follower that applies replication logs to m. m must
+// have been created with WithReaders. It may already hold strings, for
+// instance if it was loaded from a snapshot of the leader, in which case the
+// leader should ship from m.Len(). The Follower takes ownership of m: after
+// this call only use m through the Follower.
+func NewFollower(m *SymbolTab) *Follower {
+	if m.readers == nil {
+		panic("swisssymbols: NewFollower called with a SymbolTab created without WithReaders")
+	}
+	return &Follower{m: m}
+}
+
+// Close closes the Follower's SymbolTab. Make sure Apply has returned, and
+// that all Readers and Views have been closed first.
+func (f *Follower) Close() error {
+	return f.m.Close()
+}
+
+// Applied returns the Follower's watermark: the number of strings it has
+// applied. Every sequence number up to the watermark maps to the same string
+// as on the leader. Applied may be called from any goroutine.
+func (f *Follower) Applied() uint32 {
+	return f.m.readers.count.Load()
+}
+
+// Wait waits until the Follower has applied at least seq strings, or ctx is
+// done. Use it to read a string the leader has just added.
+func (f *Follower) Wait(ctx context.Context, seq uint32) error {
+	if !f.m.readers.feed.wait(ctx, f.m.readers, seq) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// NewReader returns a Reader of the strings the Follower has applied. See
+// SymbolTab.NewReader.
+func (f *Follower) NewReader() *Reader {
+	return f.m.NewReader()
+}
+
+// View returns a View of the strings the Follower has applied with sequence
+// numbers up to upTo. See SymbolTab.View.
+func (f *Follower) View(upTo uint32) *View {
+	return f.m.View(upTo)
+}
+
+// Apply reads a replication log from r and applies it. It returns nil when r
+// reaches the end of the log. Records for strings the Follower already holds
+// are checked against it, so a log may overlap the strings applied so far,
+// but it is an error if it starts beyond the watermark. Readers see each
+// string as soon as it is applied.
+func (f *Follower) Apply(r io.Reader) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m := f.m
+	defer m.pauseReuse()()
+
+	br := bufio.NewReader(r)
+	var hdr [replicationHeaderSize]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return fmt.Errorf("reading replication log header: %w", err)
+	}
+	if string(hdr[:4]) != replicationMagic {
+		return errors.New("not a swisssymbols replication log")
+	}
+	if v := binary.LittleEndian.Uint32(hdr[4:]); v != replicationVersion {
+		return fmt.Errorf("unsupported replication log version %d", v)
+	}
+	first := binary.LittleEndian.Uint32(hdr[8:])
+	if first == 0 || int(first) > m.count+1 {
+		return fmt.Errorf("replication log starts at sequence %d but only %d strings are applied", first, m.count)
+	}
+
+	var buf []byte
+	for seq := first; ; seq++ {
+		l, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading replication record %d: %w", seq, noEOF(err))
+		}
+		if l > maxStringLen {
+			return fmt.Errorf("replication record %d has length %d, which is too long", seq, l)
+		}
+		if buf, err = appendFrom(br, buf[:0], l); err != nil {
+			return fmt.Errorf("reading replication record %d: %w", seq, err)
+		}
+
+		if int(seq) <= m.count {
+			if m.SequenceToString(seq) != string(buf) {
+				return fmt.Errorf("replication record %d does not match the symbol table", seq)
+			}
+			continue
+		}
+		if _, found := m.StringToSequence(unsafe.String(unsafe.SliceData(buf), len(buf)), true); found {
+			return fmt.Errorf("replication record %d is a duplicate", seq)
+		}
+	}
+}
+
+// noEOF converts io.EOF to io.ErrUnexpectedEOF, for use when the stream ends
+// part way through a record.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
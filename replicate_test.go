@@ -0,0 +1,195 @@
+package swisssymbols
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReplication(t *testing.T) {
+	leader := New(WithReaders())
+	defer leader.Close()
+	for i := range 1000 {
+		leader.StringToSequence(strconv.Itoa(i), true)
+	}
+
+	f := NewFollower(New(WithReaders(), WithHasher(StableHasher)))
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pr, pw := io.Pipe()
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		err := leader.Ship(ctx, pw, 0)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error from Ship: %v", err)
+		}
+		pw.Close()
+	})
+	wg.Go(func() {
+		if err := f.Apply(pr); err != nil {
+			t.Errorf("unexpected error from Apply: %v", err)
+		}
+	})
+
+	// A reader on the follower checks each string as soon as the follower
+	// has it.
+	const count = 100_000
+	wg.Go(func() {
+		r := f.NewReader()
+		defer r.Close()
+		for seq := uint32(1); seq <= count; seq++ {
+			if err := f.Wait(ctx, seq); err != nil {
+				t.Errorf("waiting for %d: %v", seq, err)
+				return
+			}
+			val := strconv.Itoa(int(seq) - 1)
+			if got, found := r.StringToSequence(val, false); !found || got != seq {
+				t.Errorf("looking up %q on follower gave %d, %t", val, got, found)
+				return
+			}
+			if got := r.SequenceToString(seq); got != val {
+				t.Errorf("seq %d on follower gives %q", seq, got)
+				return
+			}
+		}
+	})
+
+	for i := 1000; i < count; i++ {
+		leader.StringToSequence(strconv.Itoa(i), true)
+	}
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer waitCancel()
+	if err := f.Wait(waitCtx, count); err != nil {
+		t.Fatalf("follower did not catch up: %v", err)
+	}
+	if f.Applied() != count {
+		t.Errorf("expected follower to have applied %d, have %d", count, f.Applied())
+	}
+	cancel()
+	wg.Wait()
+
+	v := f.View(count)
+	defer v.Close()
+	assertView(t, v, count)
+}
+
+func TestReplicationResume(t *testing.T) {
+	leader := New(WithReaders())
+	defer leader.Close()
+	for i := range 1000 {
+		leader.StringToSequence(strconv.Itoa(i), true)
+	}
+
+	f := NewFollower(New(WithReaders()))
+	defer f.Close()
+
+	// Ship a log that the follower applies in full, then one that overlaps
+	// it, then the rest.
+	for _, after := range []uint32{0, 500, 1000} {
+		if after == 1000 {
+			for i := 1000; i < 2000; i++ {
+				leader.StringToSequence(strconv.Itoa(i), true)
+			}
+		}
+		if err := f.Apply(shipLog(t, leader, after)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if f.Applied() != 2000 {
+		t.Fatalf("expected follower to have applied 2000, have %d", f.Applied())
+	}
+	v := f.View(2000)
+	defer v.Close()
+	assertView(t, v, 2000)
+}
+
+func TestReplicationErrors(t *testing.T) {
+	leader := New(WithReaders())
+	defer leader.Close()
+	for i := range 100 {
+		leader.StringToSequence(strconv.Itoa(i), true)
+	}
+	log := shipLog(t, leader, 0).Bytes()
+
+	tests := []struct {
+		name  string
+		setup func(m *SymbolTab)
+		log   []byte
+	}{
+		{name: "gap", log: shipLog(t, leader, 50).Bytes()},
+		{name: "truncated", log: log[:len(log)-1]},
+		{name: "bad magic", log: append([]byte("XXXX"), log[4:]...)},
+		{
+			name:  "mismatch",
+			setup: func(m *SymbolTab) { m.StringToSequence("a", true) },
+			log:   log,
+		},
+		{
+			name:  "duplicate",
+			setup: func(m *SymbolTab) { m.StringToSequence("50", true) },
+			log:   shipLog(t, leader, 1).Bytes(),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := New(WithReaders())
+			if test.setup != nil {
+				test.setup(m)
+			}
+			f := NewFollower(m)
+			defer f.Close()
+			if err := f.Apply(bytes.NewReader(test.log)); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestReplicationHugeRecord(t *testing.T) {
+	// A record that claims to be huge mustn't make the Follower allocate
+	// for it before the data arrives.
+	log := []byte(replicationMagic)
+	log = binary.LittleEndian.AppendUint32(log, replicationVersion)
+	log = binary.LittleEndian.AppendUint32(log, 1)
+	log = binary.AppendUvarint(log, maxStringLen)
+	log = append(log, "hat"...)
+
+	f := NewFollower(New(WithReaders()))
+	defer f.Close()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if err := f.Apply(bytes.NewReader(log)); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	runtime.ReadMemStats(&after)
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+		t.Fatalf("allocated %d bytes reading a %d byte log", alloc, len(log))
+	}
+	if f.Applied() != 0 {
+		t.Fatalf("expected nothing applied, have %d", f.Applied())
+	}
+}
+
+// shipLog returns the replication log of the strings in m after sequence
+// number after.
+func shipLog(t *testing.T, m *SymbolTab, after uint32) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	// Ship writes everything it can before it notices ctx is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.Ship(ctx, &buf, after); !errors.Is(err, context.Canceled) {
+		t.Fatalf("unexpected error from Ship: %v", err)
+	}
+	return &buf
+}
@@ -0,0 +1,263 @@
+package swisssymbols
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync/atomic"
+	"unsafe"
+)
+
+// WithSharedReaders lets other processes read a file-backed SymbolTab while
+// this one adds strings to it. The readers use OpenSharedReader. It has no
+// effect on SymbolTabs that are not file-backed.
+//
+// Readers map the file directly, so they see new strings without any help
+// from the writer. To make this safe the writer publishes the number of
+// strings in the file header as each is added, and never reuses a table
+// retired by a split while the file is open. This makes the file a little
+// bigger.
+//
+// Only the tables, the sequence number index and the strings are shared. The
+// directory that picks a table for each hash is not stored in the file, so
+// each reader builds its own from the tables it finds, and extends it as the
+// writer adds tables.
+func WithSharedReaders() Option {
+	return func(m *SymbolTab) {
+		m.shareFile = true
+	}
+}
+
+// SharedReader reads a file-backed SymbolTab that another process, or
+// another SymbolTab in this process, has opened WithSharedReaders. It maps the
+// whole file read-only, so the memory is shared with the writer and with any
+// other readers. It sees strings as soon as the writer adds them.
+//
+// A SharedReader must only be used by one goroutine at a time. If the writer
+// stops without closing the file, strings it added after its last Sync are
+// lost when the file is next opened, and SharedReaders that may have seen them
+// must be reopened. The same applies if the file is opened without
+// WithSharedReaders.
+type SharedReader struct {
+	mf *mappedFile
+	// view holds our own directory, built from the tables in the file, and
+	// the slabs and chunks we've found.
+	view  readView
+	depth uint16
+	// count is the number of strings we can see, and scanned the offset of
+	// the end of the regions we have read.
+	count   uint32
+	scanned int
+	// building holds tables a split was still filling when we read them.
+	// They are added to the directory once the split finishes.
+	building []*table
+}
+
+// OpenSharedReader opens the file at path for reading. The file must have
+// been opened WithSharedReaders, although the writer need not still have it
+// open. Close the SharedReader when it is no longer needed.
+func OpenSharedReader(path string) (*SharedReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	size := int(fi.Size())
+	if size < fileHeaderSize {
+		f.Close()
+		return nil, errors.New("file is too small to be a swisssymbols file")
+	}
+	mf := &mappedFile{f: f, readOnly: true}
+	if err := mf.reserve(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	r := &SharedReader{
+		mf:      mf,
+		view:    readView{tables: make([]*table, 1), shift: hashBits},
+		scanned: fileHeaderSize,
+	}
+	if err := r.open(); err != nil {
+		r.Close()
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	return r, nil
+}
+
+func (r *SharedReader) open() error {
+	hdr := r.mf.hdr
+	if err := hdr.check(); err != nil {
+		return err
+	}
+	if Hasher(hdr.hasher) != StableHasher {
+		return fmt.Errorf("file uses %s, not %s", Hasher(hdr.hasher), StableHasher)
+	}
+	if atomic.LoadUint32(&hdr.shared) == 0 {
+		return errors.New("file was not opened WithSharedReaders")
+	}
+	if err := r.refresh(); err != nil {
+		return err
+	}
+	for _, t := range r.view.tables {
+		if t == nil {
+			return errors.New("file is corrupt: tables do not cover all hash values")
+		}
+	}
+	return nil
+}
+
+// Close unmaps the file and closes it.
+func (r *SharedReader) Close() error {
+	r.mf.unmap()
+	return r.mf.f.Close()
+}
+
+// Len returns the number of strings in the file.
+func (r *SharedReader) Len() int {
+	r.update()
+	return int(r.count)
+}
+
+// SequenceToString looks up a string by its sequence number. It returns an
+// empty string if there is no such string.
+func (r *SharedReader) SequenceToString(seq uint32) string {
+	if seq > r.count {
+		r.update()
+	}
+	if seq == 0 || seq > r.count {
+		return ""
+	}
+	s, _ := r.view.get(seq)
+	return s
+}
+
+// StringToSequence looks up the string val and returns its sequence number.
+// found indicates whether val is present. A SharedReader can't add strings,
+// so if val is not present and addNew is true StringToSequence panics with
+// ErrReadOnly.
+func (r *SharedReader) StringToSequence(val string, addNew bool) (seq uint32, found bool) {
+	hash := StableHasher.hash(val)
+	seq = r.view.lookup(hash, val, r.newer)
+	if seq == 0 && r.update() {
+		// Our directory may point to a table that has since been split, so
+		// try again with the latest tables.
+		seq = r.view.lookup(hash, val, r.newer)
+	}
+	if seq == 0 && addNew {
+		panic(ErrReadOnly)
+	}
+	return seq, seq != 0
+}
+
+// newer updates the view and returns it.
+func (r *SharedReader) newer() *readView {
+	r.update()
+	return &r.view
+}
+
+// update catches up with the writer. It returns true if there are new
+// strings. Lookups can't return errors, so it panics if it fails to map the
+// file.
+func (r *SharedReader) update() bool {
+	if uint32(atomic.LoadUint64(&r.mf.hdr.live)) == r.count {
+		return false
+	}
+	if err := r.refresh(); err != nil {
+		panic(fmt.Sprintf("swisssymbols: reading shared file: %v", err))
+	}
+	return true
+}
+
+// refresh reads any regions the writer has added since we last looked.
+func (r *SharedReader) refresh() error {
+	mf := r.mf
+	// The writer stores liveEnd before live, so everything the first count
+	// strings need is before end.
+	count := uint32(atomic.LoadUint64(&mf.hdr.live))
+	end := int(atomic.LoadUint64(&mf.hdr.liveEnd))
+	if end > mf.mapped {
+		fi, err := mf.f.Stat()
+		if err != nil {
+			return err
+		}
+		size := int(fi.Size())
+		if end > size {
+			return fmt.Errorf("file is corrupt: data ends at %d but file is %d bytes", end, size)
+		}
+		if err := mf.mapRange(mf.mapped, size); err != nil {
+			return err
+		}
+		mf.mapped = size
+	}
+
+	r.building = slices.DeleteFunc(r.building, func(t *table) bool {
+		switch mf.region(unsafe.Pointer(t)).kind {
+		case regionTable:
+			r.addTable(t)
+			return true
+		case regionNewTable:
+			return false
+		}
+		// The split was abandoned.
+		return true
+	})
+	for off := r.scanned; off < end; {
+		rh := (*regionHeader)(mf.ptr(off))
+		p := mf.ptr(off + regionHeaderSize)
+		if off+regionHeaderSize+int(rh.size) > end {
+			return fmt.Errorf("file is corrupt: region at %d overruns the data", off)
+		}
+		switch rh.kind {
+		case regionTable:
+			r.addTable((*table)(p))
+		case regionNewTable:
+			r.building = append(r.building, (*table)(p))
+		case regionFreeTable:
+		case regionSlab:
+			r.view.slabs = append(r.view.slabs, unsafe.Slice((*int)(p), intbanksize))
+		case regionChunk:
+			r.view.chunks = append(r.view.chunks, unsafe.Slice((*byte)(p), rh.size))
+			for range int(rh.size)/stringbankSize - 1 {
+				r.view.chunks = append(r.view.chunks, nil)
+			}
+		default:
+			return fmt.Errorf("file is corrupt: unknown region type %d at %d", rh.kind, off)
+		}
+		off += regionHeaderSize + int(rh.size)
+	}
+	r.scanned = max(r.scanned, end)
+	r.count = count
+	return nil
+}
+
+// addTable adds a table to our directory. The writer only marks tables
+// retired by splits as free when it closes the file, so we see both the tables
+// that have been split and the tables they were split into. The deepest table
+// for each hash value is the one in use. The writer never replaces a table
+// with another at the same depth in a shared file, so there are no ties.
+func (r *SharedReader) addTable(t *table) {
+	for r.depth < t.localDepth {
+		tables := make([]*table, len(r.view.tables)*2)
+		for i, old := range r.view.tables {
+			tables[i*2] = old
+			tables[i*2+1] = old
+		}
+		r.view.tables = tables
+		r.depth++
+		r.view.shift--
+	}
+
+	width := 1 << (r.depth - t.localDepth)
+	index := int(t.index) * width
+	for i := index; i < index+width; i++ {
+		if old := r.view.tables[i]; old == nil || old.localDepth < t.localDepth {
+			r.view.tables[i] = t
+		}
+	}
+}
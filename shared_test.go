@@ -0,0 +1,214 @@
+package swisssymbols
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSharedReader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "symbols")
+	st, err := OpenFile(path, WithSharedReaders())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 1000 {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+
+	r, err := OpenSharedReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// The reader checks the strings it can see while the writer adds strings
+	// that split tables and grow the file.
+	const n = 300_000
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		for r.Len() < n && !t.Failed() {
+			checkSharedReader(t, r, r.Len())
+		}
+	})
+	for i := 1000; i < n; i++ {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	wg.Wait()
+	checkSharedReader(t, r, n)
+
+	// A new reader sees everything too.
+	r2, err := OpenSharedReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSharedReader(t, r2, n)
+	r2.Close()
+
+	// The reader carries on working after the writer closes the file and
+	// opens it again.
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+	st, err = OpenFile(path, WithSharedReaders())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	for i := n; i < n+100_000; i++ {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	big := strings.Repeat("big", stringbankSize)
+	st.StringToSequence(big, true)
+	checkSharedReader(t, r, n+100_000)
+	if seq, found := r.StringToSequence(big, false); !found || seq != n+100_001 {
+		t.Fatalf("expected to find big string at %d, got %d (found=%t)", n+100_001, seq, found)
+	}
+	if r.SequenceToString(n+100_001) != big {
+		t.Fatal("big string does not match")
+	}
+}
+
+func TestSharedReaderSplit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "symbols")
+	st, err := OpenFile(path, WithSharedReaders())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	r, err := OpenSharedReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// The reader looks up each string as soon as it is added, while splits
+	// start and finish, and checks everything each time a split finishes.
+	var splits int
+	for i := 0; splits < 4; i++ {
+		splitting := st.split != nil
+		val := strconv.Itoa(i)
+		st.StringToSequence(val, true)
+		if seq, found := r.StringToSequence(val, false); !found || seq != uint32(i+1) {
+			t.Fatalf("looking up %q gave %d, %t", val, seq, found)
+		}
+		if splitting && st.split == nil {
+			splits++
+			checkSharedReader(t, r, i+1)
+		}
+	}
+	if r.depth != st.tableIndexDepth {
+		t.Fatalf("reader directory has depth %d, writer's %d", r.depth, st.tableIndexDepth)
+	}
+}
+
+func TestSharedReaderNotShared(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "symbols")
+	st, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if _, err := OpenSharedReader(path); err == nil {
+		t.Fatal("expected an error opening a file that is not shared")
+	}
+}
+
+func TestSharedReaderReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "symbols")
+	st, err := OpenFile(path, WithSharedReaders())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	st.StringToSequence("a", true)
+
+	r, err := OpenSharedReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if seq, found := r.StringToSequence("a", true); !found || seq != 1 {
+		t.Fatalf("looking up a gave %d, %t", seq, found)
+	}
+	defer func() {
+		if e := recover(); e != ErrReadOnly {
+			t.Fatalf("expected ErrReadOnly panic, got %v", e)
+		}
+	}()
+	r.StringToSequence("b", true)
+}
+
+// TestSharedReaderProcess reads a file from another process while this one
+// writes to it.
+func TestSharedReaderProcess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "symbols")
+	st, err := OpenFile(path, WithSharedReaders())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	st.StringToSequence("0", true)
+
+	const n = 200_000
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSharedReaderChild$")
+	cmd.Env = append(os.Environ(), "SWISSSYMBOLS_SHARED_FILE="+path, "SWISSSYMBOLS_SHARED_COUNT="+strconv.Itoa(n))
+	var out strings.Builder
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < n; i++ {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("reader process failed: %v\n%s", err, out.String())
+	}
+}
+
+// TestSharedReaderChild is the reader process for TestSharedReaderProcess.
+func TestSharedReaderChild(t *testing.T) {
+	path := os.Getenv("SWISSSYMBOLS_SHARED_FILE")
+	if path == "" {
+		t.Skip("only run by TestSharedReaderProcess")
+	}
+	n, err := strconv.Atoi(os.Getenv("SWISSSYMBOLS_SHARED_COUNT"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenSharedReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	deadline := time.Now().Add(time.Minute)
+	for r.Len() < n && !t.Failed() {
+		if time.Now().After(deadline) {
+			t.Fatalf("only saw %d strings", r.Len())
+		}
+		checkSharedReader(t, r, r.Len())
+	}
+	checkSharedReader(t, r, n)
+}
+
+// checkSharedReader checks that r holds at least the strings "0" to count-1.
+func checkSharedReader(t *testing.T, r *SharedReader, count int) {
+	t.Helper()
+	for i := range count {
+		val := strconv.Itoa(i)
+		if seq, found := r.StringToSequence(val, false); !found || seq != uint32(i+1) {
+			t.Errorf("looking up %q gave %d, %t", val, seq, found)
+			return
+		}
+		if got := r.SequenceToString(uint32(i + 1)); got != val {
+			t.Errorf("seq %d gives %q", i+1, got)
+			return
+		}
+	}
+}
@@ -0,0 +1,530 @@
+package swisssymbols
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+	"unsafe"
+)
+
+// The snapshot format is
+//
+//	magic    [4]byte "SSYM"
+//	version  uint32
+//	base     uint64 sequence number of the string before the first string
+//	count    uint64
+//	strings  count x (uvarint length, bytes), in sequence order
+//	checksum uint32 CRC-32C of everything above
+//
+// All fixed-size integers are little-endian. Because the strings are written in
+// sequence order, loading a snapshot gives each string the same sequence number
+// it had when the snapshot was written. A full snapshot has base 0. A delta
+// containing only the strings after sequence number N has base N.
+//
+// Version 1 had no base field. Version 3 is the compact format written by
+// WriteCompactTo, which has the same header but a different body.
+const (
+	snapshotMagic          = "SSYM"
+	snapshotVersion        = 2
+	snapshotCompactVersion = 3
+
+	snapshotHeaderSize   = 24
+	snapshotV1HeaderSize = 16
+
+	// maxStringLen guards against allocating huge buffers when reading corrupt
+	// data.
+	maxStringLen = 1 << 31
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	// ErrNotEmpty is returned when loading data into a SymbolTab that already
+	// contains strings.
+	ErrNotEmpty = errors.New("swisssymbols: symbol table is not empty")
+	// ErrBadChecksum is returned when serialized data does not match its
+	// checksum.
+	ErrBadChecksum = errors.New("swisssymbols: checksum mismatch")
+	// ErrWrongBase is returned when a delta does not start at the end of the
+	// SymbolTab it is applied to.
+	ErrWrongBase = errors.New("swisssymbols: delta does not follow on from the symbol table")
+)
+
+// WriteTo writes a snapshot of the SymbolTab to w. It implements io.WriterTo.
+func (m *SymbolTab) WriteTo(w io.Writer) (n int64, err error) {
+	return m.writeSnapshot(w, 0)
+}
+
+// ExportSince writes a delta containing the strings with sequence numbers
+// after afterSeq to w. Apply it to a copy of the SymbolTab that contains
+// exactly afterSeq strings with ApplyDelta.
+func (m *SymbolTab) ExportSince(w io.Writer, afterSeq uint32) (n int64, err error) {
+	if int(afterSeq) > m.count {
+		return 0, fmt.Errorf("cannot export strings after %d: the symbol table only has %d", afterSeq, m.count)
+	}
+	return m.writeSnapshot(w, afterSeq)
+}
+
+func (m *SymbolTab) writeSnapshot(w io.Writer, afterSeq uint32) (n int64, err error) {
+	if m.deleted > 0 {
+		return 0, ErrHasDeletions
+	}
+	crc := crc32.New(crcTable)
+	cw := &countingWriter{w: io.MultiWriter(w, crc)}
+	bw := bufio.NewWriter(cw)
+
+	var hdr [snapshotHeaderSize]byte
+	copy(hdr[:4], snapshotMagic)
+	binary.LittleEndian.PutUint32(hdr[4:], snapshotVersion)
+	binary.LittleEndian.PutUint64(hdr[8:], uint64(afterSeq))
+	binary.LittleEndian.PutUint64(hdr[16:], uint64(m.count-int(afterSeq)))
+	bw.Write(hdr[:])
+
+	var lenBuf [binary.MaxVarintLen64]byte
+	for seq := int(afterSeq) + 1; seq <= m.count; seq++ {
+		val := m.SequenceToString(uint32(seq))
+		bw.Write(lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(val)))])
+		bw.WriteString(val)
+	}
+	if err := bw.Flush(); err != nil {
+		return cw.n, err
+	}
+
+	binary.LittleEndian.PutUint32(lenBuf[:], crc.Sum32())
+	l, err := w.Write(lenBuf[:4])
+	return cw.n + int64(l), err
+}
+
+// ReadFrom loads a snapshot written by WriteTo or WriteCompactTo into the
+// SymbolTab. The SymbolTab must be empty. Each string is given the sequence
+// number it had when the snapshot was written. It implements io.ReaderFrom.
+//
+// If r is an io.Seeker or an io.ByteReader, such as an *os.File, a
+// *bytes.Reader or a *bufio.Reader, ReadFrom leaves r at the end of the
+// snapshot, so more data may follow it in r. Otherwise ReadFrom may read
+// beyond the end of the snapshot. If an error is returned the SymbolTab is
+// left empty. If the SymbolTab is file-backed or has a journal, ReadFrom reads
+// and checks the whole snapshot before adding any of it, as ApplyDelta does.
+func (m *SymbolTab) ReadFrom(r io.Reader) (n int64, err error) {
+	if m.frozen {
+		return 0, ErrReadOnly
+	}
+	if m.tables == nil {
+		m.init()
+	}
+	if m.count != 0 {
+		return 0, ErrNotEmpty
+	}
+
+	sr := newSnapshotReader(r)
+	if m.file != nil || m.journal != nil {
+		// We can't empty a file-backed SymbolTab by resetting it, as that
+		// would close the file. Nor can we take back what's written to a
+		// journal. applyDelta checks the whole snapshot before adding any
+		// of it, so there's nothing to undo if it fails.
+		err := m.applyDelta(sr)
+		return sr.n, err
+	}
+	if err := m.readSnapshot(sr); err != nil {
+		m.reset()
+		return sr.n, err
+	}
+	return sr.n, nil
+}
+
+// MarshalBinary returns a snapshot of the SymbolTab in the format written by
+// WriteTo. It implements encoding.BinaryMarshaler.
+func (m *SymbolTab) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary loads a snapshot produced by MarshalBinary, WriteTo or
+// WriteCompactTo. It may be called on a zero SymbolTab, or on an empty one
+// created with New. It implements encoding.BinaryUnmarshaler.
+func (m *SymbolTab) UnmarshalBinary(data []byte) error {
+	_, err := m.ReadFrom(bytes.NewReader(data))
+	return err
+}
+
+func (m *SymbolTab) readSnapshot(sr *snapshotReader) error {
+	version, base, count, err := sr.readHeader()
+	if err != nil {
+		return err
+	}
+	if base != 0 {
+		return fmt.Errorf("%w: this is a delta after sequence %d, not a full snapshot", ErrWrongBase, base)
+	}
+	if version == snapshotCompactVersion {
+		return m.readCompact(sr, count)
+	}
+
+	var buf []byte
+	for i := range count {
+		if buf, err = sr.readString(buf, i); err != nil {
+			return err
+		}
+		// StringToSequence copies the string if it keeps it, so we can avoid
+		// allocating here.
+		if _, found := m.StringToSequence(unsafe.String(unsafe.SliceData(buf), len(buf)), true); found {
+			return fmt.Errorf("string %d is a duplicate of an earlier string", i+1)
+		}
+	}
+
+	return sr.checkChecksum()
+}
+
+// ApplyDelta adds the strings from a delta written by ExportSince to the
+// SymbolTab. The delta must start exactly where the SymbolTab ends: if the
+// delta was written with ExportSince(w, N) the SymbolTab must contain N
+// strings. A full snapshot can be applied to an empty SymbolTab.
+//
+// The whole delta is read and checked before any string is added, so if an
+// error is returned the SymbolTab is unchanged. Like ReadFrom, ApplyDelta only
+// avoids reading beyond the end of the delta if r is an io.Seeker or an
+// io.ByteReader.
+func (m *SymbolTab) ApplyDelta(r io.Reader) error {
+	return m.applyDelta(newSnapshotReader(r))
+}
+
+func (m *SymbolTab) applyDelta(sr *snapshotReader) error {
+	if m.frozen {
+		return ErrReadOnly
+	}
+	if m.tables == nil {
+		m.init()
+	}
+	defer m.pauseReuse()()
+	version, base, count, err := sr.readHeader()
+	if err != nil {
+		return err
+	}
+	if base != uint64(m.count) {
+		return fmt.Errorf("%w: delta starts after sequence %d but the symbol table has %d strings", ErrWrongBase, base, m.count)
+	}
+	if version == snapshotCompactVersion {
+		return m.applyCompact(sr, count)
+	}
+
+	// Read the strings into a single buffer
+	var data []byte
+	var ends []int
+	for i := range count {
+		if data, err = sr.appendString(data, i); err != nil {
+			return err
+		}
+		ends = append(ends, len(data))
+	}
+	if err := sr.checkChecksum(); err != nil {
+		return err
+	}
+
+	vals := make([]string, len(ends))
+	seen := make(map[string]uint32, len(ends))
+	start := 0
+	for i, end := range ends {
+		val := unsafe.String(unsafe.SliceData(data[start:end]), end-start)
+		seq := uint32(base) + uint32(i) + 1
+		if existing, found := m.StringToSequence(val, false); found {
+			return fmt.Errorf("string %d in delta is already in the symbol table at %d", seq, existing)
+		}
+		if existing, ok := seen[val]; ok {
+			return fmt.Errorf("string %d in delta is a duplicate of %d", seq, existing)
+		}
+		seen[val] = seq
+		vals[i] = val
+		start = end
+	}
+
+	for _, val := range vals {
+		m.StringToSequence(val, true)
+	}
+	return nil
+}
+
+// snapshotReader reads from a reader through its own buffer, keeping a
+// running checksum of everything read. We don't use bufio because we want to
+// update the checksum in large blocks rather than once for each read: the
+// checksum for a block of data is much cheaper than for several short pieces.
+//
+// We don't want to consume anything after the end of the snapshot. If the
+// reader is an io.Seeker we read ahead as usual, then seek back over anything
+// we didn't use once we've read the checksum. Otherwise, if it is an
+// io.ByteReader, we assume it is already buffered or reads from memory, so
+// that small reads are cheap, and only ever read as much as we need.
+type snapshotReader struct {
+	r io.Reader
+	// buf holds data read from r. buf[:pos] has been consumed, and
+	// buf[:crcPos] is included in crc.
+	buf    []byte
+	pos    int
+	crcPos int
+	crc    uint32
+	// seeker is set if we can seek back over data we read ahead. exact is
+	// set if we must not read ahead. n counts the bytes read from r.
+	seeker io.Seeker
+	exact  bool
+	n      int64
+}
+
+const snapshotBufSize = 64 * 1024
+
+func newSnapshotReader(r io.Reader) *snapshotReader {
+	s := &snapshotReader{r: r, buf: make([]byte, 0, snapshotBufSize)}
+	if seeker, ok := r.(io.Seeker); ok {
+		// Pipes are io.Seekers too, but fail to seek.
+		if _, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			s.seeker = seeker
+			return s
+		}
+	}
+	_, s.exact = r.(io.ByteReader)
+	return s
+}
+
+// readHeader reads the snapshot header, returning the version, base and
+// count.
+func (s *snapshotReader) readHeader() (version uint32, base, count uint64, err error) {
+	var hdr [snapshotHeaderSize]byte
+	if err := s.readFull(hdr[:8]); err != nil {
+		return 0, 0, 0, fmt.Errorf("reading snapshot header: %w", err)
+	}
+	if string(hdr[:4]) != snapshotMagic {
+		return 0, 0, 0, errors.New("not a swisssymbols snapshot")
+	}
+	switch version = binary.LittleEndian.Uint32(hdr[4:]); version {
+	case 1:
+		if err := s.readFull(hdr[8:snapshotV1HeaderSize]); err != nil {
+			return 0, 0, 0, fmt.Errorf("reading snapshot header: %w", err)
+		}
+		count = binary.LittleEndian.Uint64(hdr[8:])
+	case snapshotVersion, snapshotCompactVersion:
+		if err := s.readFull(hdr[8:]); err != nil {
+			return 0, 0, 0, fmt.Errorf("reading snapshot header: %w", err)
+		}
+		base = binary.LittleEndian.Uint64(hdr[8:])
+		count = binary.LittleEndian.Uint64(hdr[16:])
+	default:
+		return 0, 0, 0, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	if version == snapshotCompactVersion && base != 0 {
+		// WriteCompactTo only writes full snapshots, and the sequence
+		// numbers in the body always start at 1.
+		return 0, 0, 0, fmt.Errorf("compact snapshot has base %d, not 0", base)
+	}
+	if base+count > 1<<32-1 || base+count < base {
+		return 0, 0, 0, fmt.Errorf("snapshot count %d is too large", count)
+	}
+	return version, base, count, nil
+}
+
+// readLength reads the length of string i
+func (s *snapshotReader) readLength(i uint64) (uint64, error) {
+	l, err := s.readUvarint()
+	if err != nil {
+		return 0, fmt.Errorf("reading length of string %d: %w", i+1, err)
+	}
+	if l > maxStringLen {
+		return 0, fmt.Errorf("string %d has invalid length %d", i+1, l)
+	}
+	return l, nil
+}
+
+// readString reads string i into buf, which it may reallocate.
+func (s *snapshotReader) readString(buf []byte, i uint64) ([]byte, error) {
+	return s.appendString(buf[:0], i)
+}
+
+// appendString reads string i and appends it to dst.
+func (s *snapshotReader) appendString(dst []byte, i uint64) ([]byte, error) {
+	l, err := s.readLength(i)
+	if err != nil {
+		return dst, err
+	}
+	if dst, err = s.appendN(dst, l); err != nil {
+		return dst, fmt.Errorf("reading string %d: %w", i+1, err)
+	}
+	return dst, nil
+}
+
+// appendN reads n bytes and appends them to dst. It reads a buffer at a time
+// rather than growing dst by n up front, so a corrupt length can't make us
+// allocate much more memory than there is data.
+func (s *snapshotReader) appendN(dst []byte, n uint64) ([]byte, error) {
+	for n > 0 {
+		b, err := s.next(int(min(n, snapshotBufSize)))
+		if err != nil {
+			return dst, err
+		}
+		dst = append(dst, b...)
+		n -= uint64(len(b))
+	}
+	return dst, nil
+}
+
+// appendFrom reads n bytes from r and appends them to dst. Like appendN it
+// reads a block at a time, so a corrupt length can't make us allocate much
+// more memory than there is data. It returns io.ErrUnexpectedEOF if r ends
+// early.
+func appendFrom(r io.Reader, dst []byte, n uint64) ([]byte, error) {
+	for n > 0 {
+		chunk := int(min(n, snapshotBufSize))
+		dst = slices.Grow(dst, chunk)
+		l, err := io.ReadFull(r, dst[len(dst):len(dst)+chunk])
+		dst = dst[:len(dst)+l]
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return dst, err
+		}
+		n -= uint64(chunk)
+	}
+	return dst, nil
+}
+
+// checkChecksum reads the checksum at the end of the snapshot and checks it
+// matches the data we've read.
+func (s *snapshotReader) checkChecksum() error {
+	s.updateCRC()
+	sum := s.crc
+	tail, err := s.next(4)
+	if err != nil {
+		return fmt.Errorf("reading snapshot checksum: %w", err)
+	}
+	if binary.LittleEndian.Uint32(tail) != sum {
+		return ErrBadChecksum
+	}
+	return s.unread()
+}
+
+// unread seeks back over any data read beyond the end of the snapshot, if
+// the reader is an io.Seeker.
+func (s *snapshotReader) unread() error {
+	if s.seeker == nil || s.pos == len(s.buf) {
+		return nil
+	}
+	extra := len(s.buf) - s.pos
+	if _, err := s.seeker.Seek(-int64(extra), io.SeekCurrent); err != nil {
+		return err
+	}
+	s.buf = s.buf[:s.pos]
+	s.n -= int64(extra)
+	return nil
+}
+
+// updateCRC adds everything consumed so far to the checksum
+func (s *snapshotReader) updateCRC() {
+	s.crc = crc32.Update(s.crc, crcTable, s.buf[s.crcPos:s.pos])
+	s.crcPos = s.pos
+}
+
+// fill tries to make sure at least n bytes are buffered. n must be no more
+// than snapshotBufSize. It returns an error if it can't.
+func (s *snapshotReader) fill(n int) error {
+	if len(s.buf)-s.pos >= n {
+		return nil
+	}
+	limit := cap(s.buf)
+	if s.exact {
+		// We read just what we need. We only move the unconsumed data when
+		// we run out of room, so that we still add to the checksum in large
+		// blocks.
+		if cap(s.buf)-s.pos < n {
+			s.compact()
+		}
+		limit = s.pos + n
+	} else {
+		s.compact()
+	}
+	for len(s.buf) < n+s.pos {
+		l, err := s.r.Read(s.buf[len(s.buf):limit])
+		s.buf = s.buf[:len(s.buf)+l]
+		s.n += int64(l)
+		if err != nil {
+			if len(s.buf) >= n+s.pos {
+				break
+			}
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// compact moves the unconsumed data to the start of the buffer.
+func (s *snapshotReader) compact() {
+	s.updateCRC()
+	s.buf = s.buf[:copy(s.buf[:cap(s.buf)], s.buf[s.pos:])]
+	s.pos, s.crcPos = 0, 0
+}
+
+// next consumes the next n bytes, which must be no more than
+// snapshotBufSize. The data is only valid until the next read.
+func (s *snapshotReader) next(n int) ([]byte, error) {
+	if err := s.fill(n); err != nil {
+		return nil, err
+	}
+	s.pos += n
+	return s.buf[s.pos-n : s.pos], nil
+}
+
+// readFull fills p, which must be no more than snapshotBufSize bytes.
+func (s *snapshotReader) readFull(p []byte) error {
+	b, err := s.next(len(p))
+	if err != nil {
+		return err
+	}
+	copy(p, b)
+	return nil
+}
+
+func (s *snapshotReader) readUvarint() (uint64, error) {
+	if s.exact {
+		// Buffer the varint a byte at a time, so we don't read past it.
+		for l := 1; l <= binary.MaxVarintLen64; l++ {
+			if err := s.fill(l); err != nil {
+				return 0, err
+			}
+			if s.buf[s.pos+l-1] < 0x80 {
+				break
+			}
+		}
+	} else if len(s.buf)-s.pos < binary.MaxVarintLen64 {
+		// We may be near the end of the data, so running out of data isn't
+		// an error here. If the varint is incomplete Uvarint will tell us.
+		if err := s.fill(binary.MaxVarintLen64); err != nil && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+	}
+	v, n := binary.Uvarint(s.buf[s.pos:])
+	switch {
+	case n == 0:
+		return 0, io.ErrUnexpectedEOF
+	case n < 0:
+		return 0, errors.New("uvarint overflows 64 bits")
+	}
+	s.pos += n
+	return v, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
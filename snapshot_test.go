@@ -0,0 +1,388 @@
+package swisssymbols
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"runtime"
+	"strconv"
+	"testing"
+	"testing/iotest"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	st := New()
+	defer st.Close()
+	st.StringToSequence("", true)
+	for i := range 100_000 {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	st.StringToSequence(string(make([]byte, 300)), true)
+
+	var buf bytes.Buffer
+	written, err := st.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != int64(buf.Len()) {
+		t.Fatalf("WriteTo returned %d bytes, wrote %d", written, buf.Len())
+	}
+
+	var loaded SymbolTab
+	defer loaded.Close()
+	read, err := loaded.ReadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read != written {
+		t.Fatalf("ReadFrom returned %d bytes, expected %d", read, written)
+	}
+
+	if loaded.Len() != st.Len() {
+		t.Fatalf("expected %d strings, got %d", st.Len(), loaded.Len())
+	}
+	for seq := uint32(1); seq <= uint32(st.Len()); seq++ {
+		val := st.SequenceToString(seq)
+		if actual := loaded.SequenceToString(seq); actual != val {
+			t.Fatalf("expected %q for seq %d, got %q", val, seq, actual)
+		}
+		if actual, found := loaded.StringToSequence(val, false); !found || actual != seq {
+			t.Fatalf("expected seq %d for %q, got %d (found=%t)", seq, val, actual, found)
+		}
+	}
+}
+
+func TestSnapshotStream(t *testing.T) {
+	// Snapshots can be followed by more data in the same stream. Reading
+	// one through an io.ByteReader or io.Seeker leaves the rest of the
+	// stream alone.
+	st := New()
+	defer st.Close()
+	for i := range 10_000 {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	var buf bytes.Buffer
+	written, err := st.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.WriteCompactTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteString("trailer")
+	data := buf.Bytes()
+
+	// A *bufio.Reader is an io.ByteReader, and a *bytes.Reader is an
+	// io.Seeker.
+	for _, r := range []io.Reader{bufio.NewReader(bytes.NewReader(data)), bytes.NewReader(data)} {
+		for range 2 {
+			var loaded SymbolTab
+			if _, err := loaded.ReadFrom(r); err != nil {
+				t.Fatal(err)
+			}
+			assertSameSymbols(t, st, &loaded)
+			loaded.Close()
+		}
+		rest, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(rest) != "trailer" {
+			t.Fatalf("expected trailer after the snapshots, got %q", rest)
+		}
+	}
+
+	// Other readers are read ahead, but still give the right result.
+	var loaded SymbolTab
+	defer loaded.Close()
+	read, err := loaded.ReadFrom(struct{ io.Reader }{bytes.NewReader(data)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read <= written {
+		t.Fatalf("expected to read ahead of the %d byte snapshot, read %d", written, read)
+	}
+	assertSameSymbols(t, st, &loaded)
+}
+
+func TestMarshalBinary(t *testing.T) {
+	st := New()
+	defer st.Close()
+	st.StringToSequence("hat", true)
+	st.StringToSequence("coat", true)
+
+	data, err := st.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var loaded SymbolTab
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	defer loaded.Close()
+
+	if seq, found := loaded.StringToSequence("coat", false); !found || seq != 2 {
+		t.Fatalf("expected coat to have seq 2, got %d (found=%t)", seq, found)
+	}
+
+	if err := loaded.UnmarshalBinary(data); !errors.Is(err, ErrNotEmpty) {
+		t.Fatalf("expected ErrNotEmpty, got %v", err)
+	}
+}
+
+func TestSnapshotErrors(t *testing.T) {
+	st := New()
+	defer st.Close()
+	st.StringToSequence("hat", true)
+	st.StringToSequence("coat", true)
+	good, err := st.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt := bytes.Clone(good)
+	corrupt[len(corrupt)-6] ^= 0x01
+
+	truncated := good[:len(good)-3]
+
+	badVersion := bytes.Clone(good)
+	binary.LittleEndian.PutUint32(badVersion[4:], 99)
+
+	// A snapshot containing the same string twice
+	var dup bytes.Buffer
+	dup.Write(good[:16])
+	dup.Write(binary.LittleEndian.AppendUint64(nil, 2))
+	dup.Write([]byte{3, 'h', 'a', 't', 3, 'h', 'a', 't'})
+	dup.Write(binary.LittleEndian.AppendUint32(nil, crc32Of(dup.Bytes())))
+
+	var delta bytes.Buffer
+	if _, err := st.ExportSince(&delta, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		is   error
+	}{
+		{name: "checksum", data: corrupt, is: ErrBadChecksum},
+		{name: "truncated", data: truncated},
+		{name: "version", data: badVersion},
+		{name: "magic", data: []byte("not a snapshot at all")},
+		{name: "duplicate", data: dup.Bytes()},
+		{name: "delta", data: delta.Bytes(), is: ErrWrongBase},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var loaded SymbolTab
+			defer loaded.Close()
+			err := loaded.UnmarshalBinary(tt.data)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if tt.is != nil && !errors.Is(err, tt.is) {
+				t.Fatalf("expected %v, got %v", tt.is, err)
+			}
+			if loaded.Len() != 0 {
+				t.Fatalf("expected table to be empty after error, has %d entries", loaded.Len())
+			}
+		})
+	}
+}
+
+func TestSnapshotReadError(t *testing.T) {
+	st := New()
+	defer st.Close()
+	for i := range 100 {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	good, err := st.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wherever the read fails, we get the error from the reader rather than
+	// a complaint about the data.
+	errRead := errors.New("read failed")
+	for l := range len(good) {
+		var loaded SymbolTab
+		_, err := loaded.ReadFrom(io.MultiReader(bytes.NewReader(good[:l]), iotest.ErrReader(errRead)))
+		if !errors.Is(err, errRead) {
+			t.Fatalf("failing after %d bytes: expected %v, got %v", l, errRead, err)
+		}
+		loaded.Close()
+	}
+}
+
+func TestSnapshotVersion1(t *testing.T) {
+	var v1 bytes.Buffer
+	v1.WriteString("SSYM")
+	v1.Write(binary.LittleEndian.AppendUint32(nil, 1))
+	v1.Write(binary.LittleEndian.AppendUint64(nil, 2))
+	v1.Write([]byte{3, 'h', 'a', 't', 4, 'c', 'o', 'a', 't'})
+	v1.Write(binary.LittleEndian.AppendUint32(nil, crc32Of(v1.Bytes())))
+
+	var loaded SymbolTab
+	defer loaded.Close()
+	if err := loaded.UnmarshalBinary(v1.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if s := loaded.SequenceToString(2); s != "coat" {
+		t.Fatalf("expected coat, got %s", s)
+	}
+}
+
+func TestDelta(t *testing.T) {
+	leader := New()
+	defer leader.Close()
+	follower := New()
+	defer follower.Close()
+
+	var buf bytes.Buffer
+	for round := range 5 {
+		for i := range 1000 {
+			leader.StringToSequence(strconv.Itoa(round*1000+i), true)
+		}
+
+		buf.Reset()
+		if _, err := leader.ExportSince(&buf, uint32(follower.Len())); err != nil {
+			t.Fatal(err)
+		}
+		if err := follower.ApplyDelta(&buf); err != nil {
+			t.Fatal(err)
+		}
+		assertSameSymbols(t, leader, follower)
+	}
+
+	// An empty delta is fine
+	buf.Reset()
+	if _, err := leader.ExportSince(&buf, uint32(leader.Len())); err != nil {
+		t.Fatal(err)
+	}
+	if err := follower.ApplyDelta(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := leader.ExportSince(&buf, uint32(leader.Len()+1)); err == nil {
+		t.Fatal("expected an error exporting beyond the end of the table")
+	}
+}
+
+func TestDeltaErrors(t *testing.T) {
+	leader := New()
+	defer leader.Close()
+	leader.StringToSequence("hat", true)
+	leader.StringToSequence("coat", true)
+	leader.StringToSequence("scarf", true)
+
+	follower := New()
+	defer follower.Close()
+	follower.StringToSequence("hat", true)
+
+	// Delta starts in the wrong place
+	var buf bytes.Buffer
+	if _, err := leader.ExportSince(&buf, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := follower.ApplyDelta(&buf); !errors.Is(err, ErrWrongBase) {
+		t.Fatalf("expected ErrWrongBase, got %v", err)
+	}
+
+	// Corrupt delta leaves the follower unchanged
+	buf.Reset()
+	if _, err := leader.ExportSince(&buf, 1); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	data[len(data)-1] ^= 0xFF
+	if err := follower.ApplyDelta(bytes.NewReader(data)); !errors.Is(err, ErrBadChecksum) {
+		t.Fatalf("expected ErrBadChecksum, got %v", err)
+	}
+	if follower.Len() != 1 {
+		t.Fatalf("expected follower to be unchanged, has %d strings", follower.Len())
+	}
+
+	// A delta containing a string the follower already has
+	other := New()
+	defer other.Close()
+	other.StringToSequence("boots", true)
+	other.StringToSequence("hat", true)
+	buf.Reset()
+	if _, err := other.ExportSince(&buf, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := follower.ApplyDelta(&buf); err == nil {
+		t.Fatal("expected an error applying a delta with a duplicate string")
+	}
+	if follower.Len() != 1 {
+		t.Fatalf("expected follower to be unchanged, has %d strings", follower.Len())
+	}
+}
+
+func TestDeltaHugeLengths(t *testing.T) {
+	// A short delta whose header and lengths claim far more data than it
+	// holds must fail without allocating for the claimed sizes.
+	header := func(version uint32) []byte {
+		hdr := []byte(snapshotMagic)
+		hdr = binary.LittleEndian.AppendUint32(hdr, version)
+		hdr = binary.LittleEndian.AppendUint64(hdr, 0)
+		return binary.LittleEndian.AppendUint64(hdr, 1<<31)
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "string", data: binary.AppendUvarint(header(snapshotVersion), maxStringLen)},
+		{name: "compact", data: append(binary.AppendUvarint(header(snapshotCompactVersion), 16), 1, 2, 3)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := New()
+			defer st.Close()
+
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			if err := st.ApplyDelta(bytes.NewReader(append(tt.data, "hat"...))); err == nil {
+				t.Fatal("expected an error")
+			}
+			runtime.ReadMemStats(&after)
+			if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+				t.Fatalf("allocated %d bytes reading a %d byte delta", alloc, len(tt.data))
+			}
+			if st.Len() != 0 {
+				t.Fatalf("expected table to be empty after error, has %d entries", st.Len())
+			}
+		})
+	}
+}
+
+func crc32Of(data []byte) uint32 {
+	return crc32.Checksum(data, crcTable)
+}
+
+func BenchmarkReadFrom(b *testing.B) {
+	st := New()
+	defer st.Close()
+	for i := range 1_000_000 {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	data, err := st.MarshalBinary()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	for b.Loop() {
+		var loaded SymbolTab
+		if err := loaded.UnmarshalBinary(data); err != nil {
+			b.Fatal(err)
+		}
+		loaded.Close()
+	}
+}
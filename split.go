@@ -0,0 +1,262 @@
+package swisssymbols
+
+import (
+	"slices"
+	"unsafe"
+
+	"github.com/philpearl/mmap"
+)
+
+// Splitting a table means moving each of its entries into one of two new
+// tables. Done all at once this makes the insert that triggers the split take
+// hundreds of microseconds, most of it spent faulting in the memory for the
+// new tables. So StringToSequence spreads the work of a split over the inserts
+// that follow. Each insert does one step of the work:
+//
+//   - first we initialise a few groups of the new tables,
+//   - then we move the entries in a few groups of the old table,
+//   - then, if the new tables need a new directory, we copy part of the
+//     directory into it.
+//
+// Until the split finishes the directory still points to the old table, and
+// new entries still go into it, so lookups are unaffected. An entry added to
+// a group that has already been moved is added to the new table too. Once all
+// the work is done the new tables replace the old one in the directory.
+//
+// A table that is full mostly of deleted entries isn't split. Instead its
+// live entries are copied to a single new table in the same way, and the new
+// table takes its place. Tables in a file shared WithSharedReaders are always
+// split.
+//
+// Only one split runs at a time. Tables that pass growthThreshold meanwhile
+// wait in a queue, and each step does more work while tables are waiting. A
+// table that reaches splitLimit is split at once.
+const (
+	// splitGroupsPerStep is the number of groups each step initialises or
+	// moves.
+	splitGroupsPerStep = 8
+	// splitSlotsPerStep is the number of directory slots each step copies.
+	splitSlotsPerStep = 512
+	// splitLimit is how full a table may get while it waits to be split.
+	splitLimit = tableSize * groupSize * 7 / 8
+)
+
+// splitState is a split in progress. The entries of src are moved to lo and
+// hi. hi is nil if src is being replaced by lo rather than split.
+type splitState struct {
+	src, lo, hi *table
+	// initialised is the number of groups of lo and hi that are initialised,
+	// and moved the number of groups of src whose entries have been moved.
+	initialised int
+	moved       int
+	// dir is the directory that lo and hi will be installed in, if they need
+	// a new one, and copied is the number of slots of the current directory
+	// copied into it.
+	dir    []*table
+	copied int
+}
+
+// afterInsert is called when ent has been added to group offset of table t,
+// if t is over growthThreshold or a split is in progress.
+func (m *SymbolTab) afterInsert(t *table, offset hashValue, ent entry) {
+	s := m.split
+	switch {
+	case s != nil && t == s.src:
+		if int(offset) < s.moved {
+			s.child(ent.hash).insert(ent)
+		}
+		if t.used > splitLimit {
+			m.finishSplit()
+		}
+	case t.used <= growthThreshold:
+	case s == nil:
+		m.startSplit(t)
+	case t.used > splitLimit:
+		// We can't wait any longer.
+		m.finishSplit()
+		m.splitQueue = slices.DeleteFunc(m.splitQueue, func(q *table) bool { return q == t })
+		m.onGrowthNeeded(t)
+	case !slices.Contains(m.splitQueue, t):
+		m.splitQueue = append(m.splitQueue, t)
+	}
+	m.splitStep()
+}
+
+// splitStep does one step of the split in progress, or starts the next
+// split.
+func (m *SymbolTab) splitStep() {
+	s := m.split
+	if s == nil {
+		if len(m.splitQueue) > 0 {
+			t := m.splitQueue[0]
+			m.splitQueue = slices.Delete(m.splitQueue, 0, 1)
+			m.startSplit(t)
+		}
+		return
+	}
+	if s.advance(m, splitGroupsPerStep*(1+len(m.splitQueue))) {
+		m.finishSplit()
+	}
+}
+
+// startSplit starts splitting t.
+func (m *SymbolTab) startSplit(t *table) {
+	s := &splitState{src: t}
+	if int(t.used-t.deleted) <= growthThreshold/2 && (m.file == nil || !m.file.shared) {
+		// Deleted entries fill much of t, so we replace it instead. We
+		// never do this in a shared file: SharedReaders use the deepest
+		// table for each hash, so they can't tell which of two tables at
+		// the same depth replaced the other.
+		s.lo = m.newSplitTable(t.localDepth, t.index)
+	} else {
+		s.lo = m.newSplitTable(t.localDepth+1, t.index*2)
+		s.hi = m.newSplitTable(t.localDepth+1, t.index*2+1)
+	}
+	var err error
+	switch {
+	case s.hi != nil && t.localDepth == m.tableIndexDepth:
+		// The directory needs to double.
+		s.dir, err = mmap.Alloc[*table](len(m.tables) * 2)
+	case m.readers != nil:
+		// Readers may be using the directory, so we change a copy.
+		s.dir, err = mmap.Alloc[*table](len(m.tables))
+	}
+	if err != nil {
+		panic(err)
+	}
+	m.split = s
+}
+
+// newSplitTable returns a new table with the given depth and index. Its
+// groups are not initialised.
+func (m *SymbolTab) newSplitTable(depth, index uint16) *table {
+	m.tableCount++
+	nt := m.spareTable
+	if nt != nil {
+		m.spareTable = nil
+	} else {
+		nt = memOrAnon(m.mem).allocTable()
+	}
+	nt.localDepth = depth
+	nt.index = index
+	nt.used = 0
+	nt.deleted = 0
+	if m.file != nil {
+		// The table isn't usable until the split finishes.
+		m.file.region(unsafe.Pointer(nt)).kind = regionNewTable
+	}
+	return nt
+}
+
+// advance does up to work groups of the split, or the equivalent amount of
+// copying. It returns true once everything is done and the new tables are
+// ready to install.
+func (s *splitState) advance(m *SymbolTab, work int) bool {
+	switch {
+	case s.initialised < tableSize:
+		end := min(s.initialised+work, tableSize)
+		s.lo.initGroups(s.initialised, end)
+		if s.hi != nil {
+			s.hi.initGroups(s.initialised, end)
+		}
+		s.initialised = end
+	case s.moved < tableSize:
+		end := min(s.moved+work, tableSize)
+		for i := s.moved; i < end; i++ {
+			s.moveGroup(i)
+		}
+		s.moved = end
+	case s.dir != nil && s.copied < len(m.tables):
+		end := min(s.copied+work*(splitSlotsPerStep/splitGroupsPerStep), len(m.tables))
+		if len(s.dir) > len(m.tables) {
+			for i, t := range m.tables[s.copied:end] {
+				s.dir[(s.copied+i)*2] = t
+				s.dir[(s.copied+i)*2+1] = t
+			}
+		} else {
+			copy(s.dir[s.copied:end], m.tables[s.copied:end])
+		}
+		s.copied = end
+	default:
+		return true
+	}
+	return false
+}
+
+// moveGroup adds the entries in group i of src to lo or hi.
+func (s *splitState) moveGroup(i int) {
+	group := s.src.groups.getGroup(hashValue(i))
+	matches := group.control.findFull()
+	for matches != 0 {
+		index := matches.firstSet()
+		// This horrendous line gets the entry at index without doing a bounds check or nil check
+		ent := *(*entry)(unsafe.Add(unsafe.Pointer(&group.entries), uintptr(index)*unsafe.Sizeof(entry{})))
+		s.child(ent.hash).insert(ent)
+		matches = matches.clearFirstBit()
+	}
+}
+
+// child returns the new table for an entry with the given hash.
+func (s *splitState) child(hash hashValue) *table {
+	if s.hi != nil && hash&(1<<(hashBits-s.src.localDepth-1)) != 0 {
+		return s.hi
+	}
+	return s.lo
+}
+
+// finishSplit completes the split in progress and installs the new tables.
+func (m *SymbolTab) finishSplit() {
+	s := m.split
+	for !s.advance(m, tableSize) {
+	}
+	if s.dir != nil {
+		if len(s.dir) > len(m.tables) {
+			m.tableIndexShift--
+			m.tableIndexDepth++
+		}
+		if m.readers != nil {
+			m.readers.pending = append(m.readers.pending, retiredMem{tables: m.tables})
+		} else {
+			mmap.Free(m.tables)
+		}
+		m.tables = s.dir
+	}
+	for _, t := range []*table{s.lo, s.hi} {
+		if t == nil {
+			continue
+		}
+		m.insertTable(t)
+		if m.file != nil {
+			m.file.region(unsafe.Pointer(t)).kind = regionTable
+		}
+	}
+	m.split = nil
+
+	if m.readers != nil {
+		// Readers may still be using src, so we free it once they're done.
+		m.readers.pending = append(m.readers.pending, retiredMem{table: s.src})
+		m.readers.publish(m)
+		return
+	}
+	m.freeTable(s.src)
+}
+
+// abandonSplit abandons any split in progress, and forgets the tables
+// waiting to be split. The table being split still holds all its entries, so
+// nothing is lost. Call it before changing the tables other than with
+// StringToSequence.
+func (m *SymbolTab) abandonSplit() {
+	m.splitQueue = nil
+	s := m.split
+	if s == nil {
+		return
+	}
+	m.split = nil
+	m.freeTable(s.lo)
+	if s.hi != nil {
+		m.freeTable(s.hi)
+	}
+	if s.dir != nil {
+		mmap.Free(s.dir)
+	}
+}
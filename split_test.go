@@ -0,0 +1,175 @@
+package swisssymbols
+
+import (
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestIncrementalSplit(t *testing.T) {
+	st := New()
+	defer st.Close()
+
+	var splits, queued int
+	var last *splitState
+	const n = 1_000_000
+	for i := range n {
+		val := strconv.Itoa(i)
+		if seq, found := st.StringToSequence(val, true); found || seq != uint32(i+1) {
+			t.Fatalf("adding %q gave %d, %t", val, seq, found)
+		}
+		if st.split != nil && st.split != last {
+			splits++
+		}
+		last = st.split
+		queued = max(queued, len(st.splitQueue))
+
+		// Check strings added before, during and after the split while it
+		// is in progress.
+		if st.split != nil && i%1000 == 0 {
+			for j := range i + 1 {
+				val := strconv.Itoa(j)
+				if seq, found := st.StringToSequence(val, false); !found || seq != uint32(j+1) {
+					t.Fatalf("looking up %q during split gave %d, %t", val, seq, found)
+				}
+			}
+		}
+	}
+	if splits < 30 || queued == 0 {
+		t.Errorf("expected many splits, some queued, saw %d splits and a queue of %d", splits, queued)
+	}
+	for i := range n {
+		val := strconv.Itoa(i)
+		if seq, found := st.StringToSequence(val, false); !found || seq != uint32(i+1) {
+			t.Fatalf("looking up %q gave %d, %t", val, seq, found)
+		}
+	}
+	for _, tab := range st.tables {
+		if tab.used > splitLimit {
+			t.Errorf("table has %d entries, more than the limit", tab.used)
+		}
+	}
+}
+
+func TestSplitLimit(t *testing.T) {
+	st := New()
+	defer st.Close()
+
+	// Add strings until we have two tables and no split in progress.
+	var added []string
+	add := func(val string) {
+		st.StringToSequence(val, true)
+		added = append(added, val)
+	}
+	for i := 0; st.tableCount != 2 || st.split != nil; i++ {
+		add(strconv.Itoa(i))
+	}
+	a, b := st.tables[0], st.tables[1]
+	if a.localDepth != 1 || b.localDepth != 1 {
+		t.Fatalf("expected tables at depth 1, have %d and %d", a.localDepth, b.localDepth)
+	}
+
+	// addTo adds a string that goes in table tab.
+	var next int
+	addTo := func(tab *table) {
+		for ; ; next++ {
+			val := "x" + strconv.Itoa(next)
+			if st.tables[st.hash(val)>>st.tableIndexShift] == tab {
+				add(val)
+				next++
+				return
+			}
+		}
+	}
+
+	// Start splitting a, then pretend b is almost at the limit. It can't
+	// wait, so both splits finish straight away.
+	for st.split == nil {
+		addTo(a)
+	}
+	b.used = splitLimit
+	addTo(b)
+	if st.split != nil || len(st.splitQueue) != 0 {
+		t.Fatal("expected no split to be in progress")
+	}
+	// Tables are reused, so we check the depths rather than looking for a
+	// and b.
+	if st.tableCount != 4 {
+		t.Fatalf("expected both tables to be split, have %d tables", st.tableCount)
+	}
+	for _, tab := range st.tables {
+		if tab.localDepth != 2 {
+			t.Fatalf("expected all tables at depth 2, found one at %d", tab.localDepth)
+		}
+	}
+	for i, val := range added {
+		if seq, found := st.StringToSequence(val, false); !found || seq != uint32(i+1) {
+			t.Fatalf("looking up %q gave %d, %t", val, seq, found)
+		}
+	}
+}
+
+func TestSplitAbandoned(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "symbols")
+	st, err := OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := growthThreshold + 100
+	for i := range n {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	if st.split == nil {
+		t.Fatal("expected a split to be in progress")
+	}
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err = OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if st.tableCount != 1 {
+		t.Errorf("expected 1 table, have %d", st.tableCount)
+	}
+	for i := range n {
+		val := strconv.Itoa(i)
+		if seq, found := st.StringToSequence(val, false); !found || seq != uint32(i+1) {
+			t.Fatalf("looking up %q gave %d, %t", val, seq, found)
+		}
+	}
+	// The split starts again, and can reuse the abandoned tables.
+	for i := n; i < 3*n; i++ {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	if st.tableCount < 2 {
+		t.Errorf("expected the table to split, have %d tables", st.tableCount)
+	}
+}
+
+// BenchmarkInsertLatency reports the tail latency of adding strings.
+func BenchmarkInsertLatency(b *testing.B) {
+	const n = 1 << 22
+	vals := make([]string, n)
+	for i := range vals {
+		vals[i] = strconv.Itoa(i)
+	}
+	lat := make([]time.Duration, 0, n)
+	b.ResetTimer()
+	for range b.N {
+		st := New()
+		for _, val := range vals {
+			start := time.Now()
+			st.StringToSequence(val, true)
+			lat = append(lat, time.Since(start))
+		}
+		st.Close()
+	}
+	slices.Sort(lat)
+	b.ReportMetric(float64(lat[len(lat)*99/100]), "p99-ns")
+	b.ReportMetric(float64(lat[len(lat)*9999/10000]), "p99.99-ns")
+}
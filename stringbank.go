@@ -0,0 +1,208 @@
+package swisssymbols
+
+import (
+	"math/bits"
+	"unsafe"
+)
+
+const (
+	stringbankSize = 1 << 18 // about 250k as a power of 2
+
+	// Each chunk starts with a uint64 count of the bytes used in the chunk,
+	// including the count itself.
+	chunkHeaderSize = 8
+)
+
+// stringBank is a place to put strings. It follows the design of
+// github.com/philpearl/stringbank/offheap, but takes its memory from an
+// allocator so that the strings can live in a file. The space for a deleted
+// string can be reused for a new string that needs exactly the same space.
+//
+// Saving a string returns an int offset that can be exchanged for the string
+// via get. Each string is stored as a varint length followed by the bytes.
+type stringBank struct {
+	mem allocator
+	// chunks holds the memory for the strings. The offset of a string is
+	// chunk number * stringbankSize + offset within the chunk. A string too
+	// big for a normal chunk gets a chunk of its own, which spans several
+	// chunk numbers. Only the first of these is set.
+	chunks [][]byte
+	// current is the chunk we're currently adding strings to, and
+	// currentIndex is its position in chunks.
+	current      []byte
+	currentIndex int
+	// free holds the offsets of released space, keyed by the size of the
+	// space.
+	free map[int][]int
+}
+
+func (s *stringBank) close() {
+	mem := memOrAnon(s.mem)
+	for _, c := range s.chunks {
+		if c != nil {
+			mem.freeChunk(c)
+		}
+	}
+	s.chunks = nil
+	s.current = nil
+	s.free = nil
+}
+
+// Size returns the approximate number of bytes in the string bank. The
+// estimate includes currently unused and wasted space
+func (s *stringBank) Size() int {
+	return len(s.chunks) * stringbankSize
+}
+
+// Get converts an offset to the original string
+func (s *stringBank) Get(index int) string {
+	data := s.chunks[index/stringbankSize]
+	offset := index % stringbankSize
+	if l := data[offset]; l&0x80 == 0 {
+		b := data[offset+1 : offset+1+int(l)]
+		return unsafe.String(unsafe.SliceData(b), len(b))
+	}
+	l, llen := readLength(data[offset:])
+	b := data[offset+llen : offset+llen+l]
+	return unsafe.String(unsafe.SliceData(b), len(b))
+}
+
+// Save copies a string into the stringBank, and returns the offset of the
+// string in the bank
+func (s *stringBank) Save(tocopy string) int {
+	l := len(tocopy)
+	if l <= 0x7F {
+		// fast-track easy case
+		offset, buf := s.reserve(l + 1)
+		buf[0] = byte(l)
+		copy(buf[1:], tocopy)
+		return offset
+	}
+	offset, buf := s.reserve(l + spaceForLength(l))
+	start := writeLength(l, buf)
+	copy(buf[start:], tocopy)
+	return offset
+}
+
+// reserve finds a contiguous space of length l that can be used for writing
+// data
+func (s *stringBank) reserve(l int) (index int, data []byte) {
+	if len(s.free) > 0 {
+		if free := s.free[l]; len(free) > 0 {
+			index = free[len(free)-1]
+			if len(free) == 1 {
+				delete(s.free, l)
+			} else {
+				s.free[l] = free[:len(free)-1]
+			}
+			offset := index % stringbankSize
+			return index, s.chunks[index/stringbankSize][offset : offset+l]
+		}
+	}
+
+	if l > stringbankSize-chunkHeaderSize {
+		// This needs a chunk all to itself
+		size := (l + chunkHeaderSize + stringbankSize - 1) &^ (stringbankSize - 1)
+		c := s.addChunk(size)
+		index = len(s.chunks) - size/stringbankSize
+		setChunkUsed(c, chunkHeaderSize+l)
+		return index*stringbankSize + chunkHeaderSize, c[chunkHeaderSize : chunkHeaderSize+l]
+	}
+
+	used := 0
+	if s.current != nil {
+		used = chunkUsed(s.current)
+	}
+	if s.current == nil || used+l > len(s.current) {
+		s.current = s.addChunk(stringbankSize)
+		s.currentIndex = len(s.chunks) - 1
+		used = chunkHeaderSize
+	}
+	setChunkUsed(s.current, used+l)
+	return s.currentIndex*stringbankSize + used, s.current[used : used+l]
+}
+
+// release frees the space used by the string at index, so that Save can
+// reuse it.
+func (s *stringBank) release(index int) {
+	data := s.chunks[index/stringbankSize]
+	offset := index % stringbankSize
+	l, llen := readLength(data[offset:])
+	if size := l + llen; size > stringbankSize-chunkHeaderSize {
+		// The string has a chunk to itself, so we free the chunk.
+		memOrAnon(s.mem).freeChunk(data)
+		s.chunks[index/stringbankSize] = nil
+	} else {
+		if s.free == nil {
+			s.free = make(map[int][]int)
+		}
+		s.free[size] = append(s.free[size], index)
+	}
+}
+
+// addChunk allocates a new chunk. size is a multiple of stringbankSize
+func (s *stringBank) addChunk(size int) []byte {
+	c := memOrAnon(s.mem).allocChunk(size)
+	setChunkUsed(c, chunkHeaderSize)
+	s.chunks = append(s.chunks, c)
+	for range size/stringbankSize - 1 {
+		s.chunks = append(s.chunks, nil)
+	}
+	return c
+}
+
+// restore sets up the stringBank with chunks that already contain strings.
+// It is used when opening a file-backed SymbolTab.
+func (s *stringBank) restore(c []byte) {
+	s.chunks = append(s.chunks, c)
+	for range len(c)/stringbankSize - 1 {
+		s.chunks = append(s.chunks, nil)
+	}
+	if len(c) == stringbankSize {
+		s.current = c
+		s.currentIndex = len(s.chunks) - 1
+	}
+}
+
+func chunkUsed(c []byte) int {
+	return int(*(*uint64)(unsafe.Pointer(unsafe.SliceData(c))))
+}
+
+func setChunkUsed(c []byte, used int) {
+	*(*uint64)(unsafe.Pointer(unsafe.SliceData(c))) = uint64(used)
+}
+
+func spaceForLength(len int) int {
+	// 7 bits => 1 byte
+	// 8 bits => 2 byte
+	bits := bits.Len(uint(len))
+	return (bits + 6) / 7
+}
+
+func writeLength(len int, buf []byte) int {
+	// Want to write the length in a compact manner, with the assumption that
+	// short lengths are much more common
+	remainder := len
+	var i int
+	for i = 0; remainder != 0; i++ {
+		val := byte(remainder & 0x7F)
+		remainder = remainder >> 7
+		if remainder != 0 {
+			val |= 0x80
+		}
+		buf[i] = val
+	}
+	return i
+}
+
+func readLength(buf []byte) (int, int) {
+	total := 0
+	for i, val := range buf {
+		total += int(val&0x7F) << (7 * uint(i))
+		if val&0x80 == 0 {
+			return total, int(i + 1)
+		}
+	}
+	// Shouldn't get here as the buffer should always be big enough
+	panic("read length overrun")
+}
@@ -3,31 +3,97 @@
 // very memory efficient and fast. It holds all data off-heap.
 //
 // It is based on the swiss-table design for a hash table.
+//
+// A SymbolTab can also live in a file, so that it survives restarts. See
+// OpenFile.
 package swisssymbols
 
 import (
+	"fmt"
+	"syscall"
 	"unsafe"
 
 	"github.com/philpearl/mmap"
-	stringbank "github.com/philpearl/stringbank/offheap"
 )
 
 type SymbolTab struct {
 	tables []*table
 
 	spareTable      *table
-	sb              stringbank.Stringbank
+	sb              stringBank
 	ib              intbank
 	count           int
 	tableCount      int
 	tableIndexShift uint16
 	tableIndexDepth uint16
+
+	hasher Hasher
+	// mem provides memory for tables. ib and sb also have a reference to it.
+	mem allocator
+	// file is set if the SymbolTab is file-backed
+	file *mappedFile
+	// journal, if set, records each new string
+	journal *Journal
+	// readers is set if the SymbolTab can have Readers. See WithReaders.
+	readers *readerState
+	// frozen is set once the SymbolTab is read-only, and protected once its
+	// memory is also protected. See Freeze and Protect.
+	frozen    bool
+	protected bool
+	// lookupFlags summarises the modes above and below that change what a
+	// lookup does, so that lookups only need to check one field. See
+	// setLookupFlags.
+	lookupFlags uint8
+	// split is the table split in progress, if any, and splitQueue holds
+	// tables waiting to be split. See split.go.
+	split      *splitState
+	splitQueue []*table
+
+	// shareFile is set if a file-backed SymbolTab should let other processes
+	// read it. See WithSharedReaders.
+	shareFile bool
+
+	// deleted is the number of deleted strings. Their sequence numbers form a
+	// list starting at freeSeq. See delete.go.
+	deleted   int
+	freeSeq   uint32
+	reuseSeqs bool
+
+	// generations is set if strings that aren't used are evicted, and used
+	// holds the generation each string was last used in. See
+	// WithGenerations.
+	generations uint32
+	generation  uint32
+	used        uint32bank
+
+	// refs holds the number of holders of each string. See Acquire.
+	refs uint32bank
 }
 
-func New() *SymbolTab {
-	m := SymbolTab{
-		tableIndexShift: hashBits,
+// Option configures a SymbolTab
+type Option func(m *SymbolTab)
+
+// WithHasher sets the hash function used by the SymbolTab.
+func WithHasher(h Hasher) Option {
+	return func(m *SymbolTab) {
+		m.hasher = h
 	}
+}
+
+// New creates a new, empty SymbolTab. Close it when it is no longer needed to
+// release its memory.
+func New(opts ...Option) *SymbolTab {
+	var m SymbolTab
+	for _, opt := range opts {
+		opt(&m)
+	}
+	m.init()
+	return &m
+}
+
+func (m *SymbolTab) init() {
+	m.tableIndexShift = hashBits
+	m.setLookupFlags()
 
 	var err error
 	m.tables, err = mmap.Alloc[*table](1)
@@ -35,25 +101,67 @@ func New() *SymbolTab {
 		panic(err)
 	}
 	m.tables[0] = m.newTable()
-
-	return &m
+	if m.readers != nil {
+		m.readers.publish(m)
+	}
 }
 
-func (m *SymbolTab) Close() {
-	m.sb.Close()
-	m.ib.close()
-	for _, t := range m.tables {
-		m.freeTable(t)
+// Close releases the resources used by the SymbolTab. For a file-backed
+// SymbolTab it flushes all changes to disk and closes the file.
+func (m *SymbolTab) Close() error {
+	var err error
+	if m.protected {
+		if err := m.mprotect(syscall.PROT_READ | syscall.PROT_WRITE); err != nil {
+			return err
+		}
 	}
-	if m.spareTable != nil {
-		m.freeTable(m.spareTable)
+	m.abandonSplit()
+	if m.readers != nil {
+		// There are no Readers now, so we can free everything they might
+		// have been using.
+		m.readers.reclaim(m, true)
+	}
+	if m.file != nil {
+		// The tables, slabs and chunks all live in the file, and are
+		// released when it is unmapped.
+		err = m.file.close(m.count)
+	} else {
+		m.sb.close()
+		m.ib.close()
+		for i, t := range m.tables {
+			// A table may occupy several adjacent slots in the directory.
+			// Only free it once.
+			if i > 0 && m.tables[i-1] == t {
+				continue
+			}
+			m.freeTable(t)
+		}
+		if m.spareTable != nil {
+			m.freeTable(m.spareTable)
+		}
 	}
-	mmap.Free(m.tables)
+	if m.tables != nil {
+		mmap.Free(m.tables)
+	}
+	m.used.close()
+	m.refs.close()
+	*m = SymbolTab{}
+	return err
+}
+
+// reset discards the contents of the SymbolTab, leaving it empty and ready for
+// use. It closes any file, so it must not be used on a file-backed SymbolTab.
+func (m *SymbolTab) reset() {
+	hasher, readers, reuseSeqs, generations := m.hasher, m.readers, m.reuseSeqs, m.generations
+	m.Close()
+	m.hasher, m.readers, m.reuseSeqs, m.generations = hasher, readers, reuseSeqs, generations
+	m.init()
 }
 
-// Len returns the number of unique strings stored
+// Len returns the number of unique strings stored. Deleted strings are not
+// counted.
 func (m *SymbolTab) Len() int {
-	return m.count
+	return m.count - m.deleted
 }
 
 // Cap returns the size of the SymbolTab table
@@ -68,10 +176,14 @@ func (m *SymbolTab) SymbolSize() int {
 }
 
 // SequenceToString looks up a string by its sequence number. Obtain the sequence number
-// for a string with StringToSequence
+// for a string with StringToSequence. It returns an empty string if the string has been
+// deleted.
 func (m *SymbolTab) SequenceToString(seq uint32) string {
 	// Look up the stringbank offset for this sequence number, then get the string
 	offset := m.ib.lookup(seq)
+	if offset < 0 {
+		return ""
+	}
 	return m.sb.Get(offset)
 }
 
@@ -83,14 +195,37 @@ func (m *SymbolTab) SequenceToString(seq uint32) string {
 // - [ ] instrinsics for bit ops - can't make a version that works without AVX512
 // - [X] different probe sequence. Maybe a bit better?
 // - [ ] prefetch next group in probe sequence
+// - [X] prefetch groups for a batch of lookups - see StringsToSequences
 
 const growthThreshold = tableSize * groupSize * 3 / 4
 
 // StringToSequence looks up the string val and returns its sequence number seq. If val does
 // not currently exist in the symbol table, it will add it if addNew is true. found indicates
-// whether val was already present in the SymbolTab
+// whether val was already present in the SymbolTab. If the SymbolTab is frozen and val is
+// not present, StringToSequence panics with ErrReadOnly if addNew is true, as the other
+// read-only types do. Use TryStringToSequence to get ErrReadOnly as an error instead.
+//
+// This is the hot path for lookups, so anything that only some SymbolTabs need
+// is kept out of it. Adding a string is done by insert, and the checks for the
+// modes that change what a lookup does are folded into m.lookupFlags.
 func (m *SymbolTab) StringToSequence(val string, addNew bool) (seq uint32, found bool) {
-	hash := hash(val)
+	// This is small enough to be inlined, so there is only one call.
+	return m.stringToSequence(val, 0, false, addNew)
+}
+
+// stringToSequence is StringToSequence. If hashed is true, hash is the hash
+// of val, as computed by m.hash. Otherwise stringToSequence computes it.
+// Computing the hash here rather than in StringToSequence saves a call.
+func (m *SymbolTab) stringToSequence(val string, hash hashValue, hashed, addNew bool) (seq uint32, found bool) {
+	if !hashed {
+		if m.hasher == RuntimeHasher {
+			// Calling runtimeHash directly rather than through
+			// Hasher.hash saves a call in the common case.
+			hash = runtimeHash(val)
+		} else {
+			hash = m.hasher.hash(val)
+		}
+	}
 	t := m.tables[hash>>hashValue(m.tableIndexShift)]
 	if t == nil {
 		// remove repeated nilcheck by checking here
@@ -107,14 +242,16 @@ func (m *SymbolTab) StringToSequence(val string, addNew bool) (seq uint32, found
 			ent := (*entry)(unsafe.Add(unsafe.Pointer(&group.entries), uintptr(index)*unsafe.Sizeof(entry{})))
 			if ent.hash == hash {
 				if seq := ent.seq; m.sb.Get(m.ib.lookup(seq)) == val {
-					return ent.seq, true
+					if m.lookupFlags&lookupTouch != 0 {
+						m.touch(seq)
+					}
+					return seq, true
 				}
 			}
 			matches = matches.clearFirstBit()
 		}
 
-		empty := group.control.findEmpty()
-		if empty == 0 {
+		if group.control.findEmpty() == 0 {
 			continue
 		}
 		// There is an empty slot, so we've reached the end of the probe
@@ -122,22 +259,115 @@ func (m *SymbolTab) StringToSequence(val string, addNew bool) (seq uint32, found
 		if !addNew {
 			return 0, false
 		}
+		if m.lookupFlags&lookupReadOnly != 0 {
+			panic(ErrReadOnly)
+		}
+		return m.insert(val, hash, t, group, probe.offset), false
+	}
+}
+
+// Bits for SymbolTab.lookupFlags.
+const (
+	// lookupTouch is set if lookups must record that a string was used. See
+	// WithGenerations.
+	lookupTouch uint8 = 1 << iota
+	// lookupReadOnly is set if the SymbolTab is frozen.
+	lookupReadOnly
+)
 
-		index := empty.firstSet()
+// setLookupFlags recalculates m.lookupFlags. Call it whenever one of the
+// fields it depends on changes.
+func (m *SymbolTab) setLookupFlags() {
+	m.lookupFlags = 0
+	if m.frozen {
+		m.lookupFlags |= lookupReadOnly
+	} else if m.generations != 0 {
+		// Lookups in a frozen SymbolTab don't change it, so aren't recorded.
+		m.lookupFlags |= lookupTouch
+	}
+}
+
+// insert adds val, which is not present, to the SymbolTab. group is the group
+// at offset in table t where the probe sequence for hash found an empty slot.
+// It returns the new string's sequence number.
+//
+//go:noinline
+func (m *SymbolTab) insert(val string, hash hashValue, t *table, group *group, offset hashValue) (seq uint32) {
+	if t.deleted != 0 {
+		// A deleted slot earlier in the probe sequence can take the
+		// entry.
+		group, offset = t.firstSpace(hash)
+	}
+	if m.reuseSeqs && m.freeSeq != 0 {
+		seq = m.reuseSeq()
+	} else {
+		seq = uint32(m.count + 1)
+		if m.journal != nil {
+			if err := m.journal.append(seq, val); err != nil {
+				panic(fmt.Sprintf("swisssymbols: writing journal: %v", err))
+			}
+		}
 		m.count++
-		seq = uint32(m.count)
-		m.ib.save(seq, m.sb.Save(val))
+	}
+	m.ib.save(seq, m.sb.Save(val))
+	if m.generations != 0 {
+		m.touch(seq)
+	}
+	index := t.take(group)
 
-		// This horrendous line sets the entry at index without doing a bounds check or nil check
-		*(*entry)(unsafe.Add(unsafe.Pointer(&group.entries), uintptr(index)*unsafe.Sizeof(entry{}))) = entry{seq: seq, hash: hash}
+	// This horrendous line sets the entry at index without doing a bounds check or nil check
+	*(*entry)(unsafe.Add(unsafe.Pointer(&group.entries), uintptr(index)*unsafe.Sizeof(entry{}))) = entry{seq: seq, hash: hash}
+	groupHash := hashValue(hash & 0x7F)
+	if m.readers != nil {
+		m.readers.insert(m, group, index, groupHash)
+	} else if m.file != nil && m.file.shared {
+		// Readers in other processes may be looking at the group.
+		c := group.control
+		c.set(index, groupHash)
+		storeControl(&group.control, c)
+	} else {
 		group.control.set(index, groupHash)
-		t.used++
-		if t.used > growthThreshold {
-			// Table is too full, need to grow
-			m.onGrowthNeeded(t)
+	}
+	if t.used > growthThreshold || m.split != nil {
+		// Table is too full, or we're part way through splitting one.
+		m.afterInsert(t, offset, entry{seq: seq, hash: hash})
+	}
+	if m.file != nil && m.file.shared {
+		m.file.publish(m.count)
+	}
+	return seq
+}
+
+// TryStringToSequence is like StringToSequence, but returns ErrReadOnly rather
+// than panicking if the SymbolTab is frozen and val is not present and addNew
+// is true.
+func (m *SymbolTab) TryStringToSequence(val string, addNew bool) (seq uint32, found bool, err error) {
+	if m.frozen && addNew {
+		if seq, found = m.StringToSequence(val, false); !found {
+			return 0, false, ErrReadOnly
 		}
+		return seq, true, nil
+	}
+	seq, found = m.StringToSequence(val, addNew)
+	return seq, found, nil
+}
 
-		return seq, false
+// insertNew adds the entry for the string with sequence number seq and hash
+// hash, without checking whether the string is already present. It is for
+// loading data where the sequence numbers are known and the strings are known
+// to be unique. The string must already be saved in the stringBank and
+// intbank. It does not write to the journal or change the count.
+func (m *SymbolTab) insertNew(seq uint32, hash hashValue) {
+	if m.split != nil {
+		m.abandonSplit()
+	}
+	t := m.tables[hash>>hashValue(m.tableIndexShift)]
+	if m.generations != 0 {
+		m.touch(seq)
+	}
+	t.insert(entry{seq: seq, hash: hash})
+	if t.used > growthThreshold {
+		m.onGrowthNeeded(t)
 	}
 }
 
@@ -148,25 +378,21 @@ func (m *SymbolTab) newTable() *table {
 		m.spareTable = nil
 		return t
 	}
-	tables, err := mmap.Alloc[table](1)
-	if err != nil {
-		panic(err)
-	}
-	t := &tables[0]
+	t := memOrAnon(m.mem).allocTable()
 	t.init()
 	return t
 }
 
 func (m *SymbolTab) freeTable(t *table) {
 	m.tableCount--
-	if m.spareTable == nil {
+	// A file-backed SymbolTab keeps track of free tables in the file, so we
+	// don't keep a spare.
+	if m.spareTable == nil && m.file == nil {
 		t.init()
 		m.spareTable = t
 		return
 	}
-	if err := mmap.Free(unsafe.Slice(t, 1)); err != nil {
-		panic(err)
-	}
+	memOrAnon(m.mem).freeTable(t)
 }
 
 // This is called when a table detects it is too full and needs to grow.
@@ -174,6 +400,9 @@ func (m *SymbolTab) onGrowthNeeded(t *table) {
 	if t.localDepth == m.tableIndexDepth {
 		// Need to grow the directory. This will take care of splitting tables as needed.
 		m.grow()
+	} else if m.readers != nil {
+		// Readers may be using the directory, so we change a copy.
+		m.copyDirectory()
 	}
 
 	// We can just split this table, and split up the slots it is currently
@@ -181,13 +410,19 @@ func (m *SymbolTab) onGrowthNeeded(t *table) {
 	oldTab, newTab := t.split(m)
 	m.insertTable(oldTab)
 	m.insertTable(newTab)
+	if m.readers != nil {
+		// Readers may still be using t, so we free it once they're done.
+		m.readers.pending = append(m.readers.pending, retiredMem{table: t})
+		m.readers.publish(m)
+		return
+	}
 	m.freeTable(t)
 }
 
 func (m *SymbolTab) insertTable(t *table) {
 	depthDifference := m.tableIndexDepth - t.localDepth
-	index := t.index * (depthDifference + 1)
-	tableWidth := uint16(1 << depthDifference)
+	index := int(t.index) << depthDifference
+	tableWidth := 1 << depthDifference
 	for i := range tableWidth {
 		m.tables[index+i] = t
 	}
@@ -208,6 +443,10 @@ func (m *SymbolTab) grow() {
 	}
 	m.tableIndexShift--
 	m.tableIndexDepth++
-	mmap.Free(m.tables)
+	if m.readers != nil {
+		m.readers.pending = append(m.readers.pending, retiredMem{tables: m.tables})
+	} else {
+		mmap.Free(m.tables)
+	}
 	m.tables = newTables
 }
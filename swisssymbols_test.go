@@ -154,6 +154,40 @@ func TestGrowth2(t *testing.T) {
 	}
 }
 
+func TestShallowTable(t *testing.T) {
+	st := New()
+	defer st.Close()
+
+	var added []string
+	addWithPrefix := func(name string, prefix hashValue, prefixBits, n int) {
+		for i := 0; n > 0; i++ {
+			val := name + strconv.Itoa(i)
+			if st.hash(val)>>(hashBits-prefixBits) != prefix {
+				continue
+			}
+			st.StringToSequence(val, true)
+			added = append(added, val)
+			n--
+		}
+	}
+
+	// Strings whose hashes start 0000 make the directory at least 4 levels
+	// deep, while the table for hashes starting 1 stays at depth 1.
+	addWithPrefix("a", 0, 4, 2*growthThreshold)
+	if st.tableIndexDepth < 4 {
+		t.Fatalf("expected directory depth of at least 4, have %d", st.tableIndexDepth)
+	}
+	// Splitting the table for 1 installs tables that are 2 or more levels
+	// shallower than the directory.
+	addWithPrefix("b", 1, 1, 2*growthThreshold)
+
+	for i, val := range added {
+		if seq, found := st.StringToSequence(val, false); !found || seq != uint32(i+1) {
+			t.Fatalf("looking up %q gave %d, %t", val, seq, found)
+		}
+	}
+}
+
 func TestAddNew(t *testing.T) {
 	st := New()
 	defer st.Close()
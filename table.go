@@ -20,10 +20,13 @@ type table struct {
 	// localDepth is the number of bits of the hash used to pick this table in
 	// the extensible hashing scheme.
 	localDepth uint16
-	// used is the number of entries in the table
+	// used is the number of entries in the table, including deleted entries,
+	// as they still lengthen probe sequences.
 	used uint16
 	// This is the index of this table in the map's table index.
 	index uint16
+	// deleted is the number of deleted entries in the table.
+	deleted uint16
 }
 
 type groups [tableSize]group
@@ -32,12 +35,18 @@ func (t *table) init() {
 	if t == nil {
 		panic("initializing nil table")
 	}
-	for i := range t.groups {
-		t.groups[i].init()
-	}
+	t.initGroups(0, tableSize)
 	t.localDepth = 0
 	t.used = 0
 	t.index = 0
+	t.deleted = 0
+}
+
+// initGroups initialises groups [from, to) of the table
+func (t *table) initGroups(from, to int) {
+	for i := from; i < to; i++ {
+		t.groups[i].init()
+	}
 }
 
 // getGroup returns the group at index i, but avoids doing a bounds check. Only
@@ -46,21 +55,6 @@ func (gs *groups) getGroup(i hashValue) *group {
 	return (*group)(unsafe.Add(unsafe.Pointer(gs), uintptr(i)*unsafe.Sizeof(group{})))
 }
 
-func hash(key string) hashValue {
-	return hashValue(runtime_memhash(
-		unsafe.Pointer(unsafe.StringData(key)),
-		0,
-		uintptr(len(key)),
-	))
-}
-
-// We use the runtime's map hash function without the overhead of using
-// hash/maphash
-//
-//go:linkname runtime_memhash runtime.memhash
-//go:noescape
-func runtime_memhash(p unsafe.Pointer, seed, s uintptr) uintptr
-
 // Insert is used when splitting a table to insert an entry into the table.
 // Inserting should never cause growth!
 func (t *table) insert(ent entry) {
@@ -72,16 +66,14 @@ func (t *table) insert(ent entry) {
 
 	for range t.groups {
 		group := t.groups.getGroup(probe.offset)
-		// We're not looking for matches, only empty spaces
-		if empty := group.control.findEmpty(); empty != 0 {
-			// There is an empty slot, so we've reached the end of the probe
-			// sequence and the key is not present in the map.
+		// We're not looking for matches, only spaces
+		if group.control.findEmptyOrDeleted() != 0 {
+			index := t.take(group)
 
 			// This horrendous line sets the entry at index without doing a bounds check or nil check
-			*(*entry)(unsafe.Add(unsafe.Pointer(&group.entries), uintptr(empty.firstSet())*unsafe.Sizeof(entry{}))) = ent
+			*(*entry)(unsafe.Add(unsafe.Pointer(&group.entries), uintptr(index)*unsafe.Sizeof(entry{}))) = ent
 
-			group.control.set(empty.firstSet(), ent.hash&0x7F)
-			t.used++
+			group.control.set(index, ent.hash&0x7F)
 			return
 		}
 		// Continue to next group in case of hash collision
@@ -91,6 +83,79 @@ func (t *table) insert(ent entry) {
 	panic("table is full")
 }
 
+// take returns the first empty or deleted slot in group, which must have one,
+// and counts it as used. The caller sets the entry and then the control byte.
+func (t *table) take(group *group) int {
+	index := group.control.findEmptyOrDeleted().firstSet()
+	// No slot before index is empty, so the slot is empty only if it is the
+	// first empty slot.
+	if empty := group.control.findEmpty(); empty != 0 && empty.firstSet() == index {
+		t.used++
+	} else {
+		t.deleted--
+	}
+	return index
+}
+
+// firstSpace returns the first group in the probe sequence for hash that has
+// an empty or deleted slot, and its offset.
+func (t *table) firstSpace(hash hashValue) (*group, hashValue) {
+	for probe := makeProbeSeq(hash>>7, tableMask); ; probe = probe.next() {
+		if group := t.groups.getGroup(probe.offset); group.control.findEmptyOrDeleted() != 0 {
+			return group, probe.offset
+		}
+	}
+}
+
+// remove deletes the entry with the given hash and sequence number from the
+// table. It returns the offset of the group that held the entry, and false if
+// the entry was not found.
+func (t *table) remove(hash hashValue, seq uint32) (offset hashValue, found bool) {
+	for probe := makeProbeSeq(hash>>7, tableMask); ; probe = probe.next() {
+		group := t.groups.getGroup(probe.offset)
+		matches := group.control.findMatches(hash & 0x7F)
+		for matches != 0 {
+			index := matches.firstSet()
+			// This horrendous line gets the entry at index without doing a bounds check or nil check
+			ent := (*entry)(unsafe.Add(unsafe.Pointer(&group.entries), uintptr(index)*unsafe.Sizeof(entry{})))
+			if ent.hash == hash && ent.seq == seq {
+				t.clear(group, index)
+				return probe.offset, true
+			}
+			matches = matches.clearFirstBit()
+		}
+		if group.control.findEmpty() != 0 {
+			return 0, false
+		}
+	}
+}
+
+// renumber changes the sequence number of each entry in the table from seq to
+// newSeqs[seq].
+func (t *table) renumber(newSeqs []uint32) {
+	for i := range t.groups {
+		group := t.groups.getGroup(hashValue(i))
+		matches := group.control.findFull()
+		for matches != 0 {
+			index := matches.firstSet()
+			group.entries[index].seq = newSeqs[group.entries[index].seq]
+			matches = matches.clearFirstBit()
+		}
+	}
+}
+
+// clear marks slot index of group as deleted. If the group has an empty slot
+// no probe sequence continues past it, so the slot can be made empty instead.
+func (t *table) clear(group *group, index int) {
+	if group.control.findEmpty() != 0 {
+		group.control.set(index, controlEmpty)
+		t.used--
+		return
+	}
+	group.control.set(index, controlDeleted)
+	t.deleted++
+}
+
 // split splits the table, returning a new table containing hopefully half of
 // the entries.
 func (t *table) split(m *SymbolTab) (oldTab, newTab *table) {
@@ -0,0 +1,225 @@
+package swisssymbols
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ExportText writes the strings in the SymbolTab to w, one per line in
+// sequence order. Each line is the sequence number, a tab, then the string.
+// Strings that contain newlines or carriage returns, that start with a double
+// quote, or that are not valid UTF-8 are written as Go quoted strings.
+func (m *SymbolTab) ExportText(w io.Writer) error {
+	if m.deleted > 0 {
+		return ErrHasDeletions
+	}
+	bw := bufio.NewWriter(w)
+	var buf []byte
+	for seq := uint32(1); seq <= uint32(m.count); seq++ {
+		val := m.SequenceToString(seq)
+		buf = strconv.AppendUint(buf[:0], uint64(seq), 10)
+		buf = append(buf, '\t')
+		if needsQuoting(val) {
+			buf = strconv.AppendQuote(buf, val)
+		} else {
+			buf = append(buf, val...)
+		}
+		buf = append(buf, '\n')
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func needsQuoting(val string) bool {
+	return strings.HasPrefix(val, `"`) ||
+		strings.ContainsAny(val, "\r\n") ||
+		!utf8.ValidString(val)
+}
+
+// ImportText reads strings written by ExportText and adds them to the
+// SymbolTab. The sequence numbers must carry on from the last sequence number
+// in the SymbolTab, with no gaps, so each string gets the sequence number
+// recorded for it. It is an error if a string is already in the SymbolTab.
+//
+// Blank lines and lines starting with # are ignored. The whole input is read
+// and checked before any string is added, so if an error is returned the
+// SymbolTab is unchanged.
+func (m *SymbolTab) ImportText(r io.Reader) error {
+	if m.frozen {
+		return ErrReadOnly
+	}
+	defer m.pauseReuse()()
+	ti := textImport{m: m}
+	br := bufio.NewReader(r)
+	for lineNo := 1; ; lineNo++ {
+		line, err := br.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// The line is longer than the buffer. Fall back to accumulating
+			// it.
+			line = bytes.Clone(line)
+			var more []byte
+			more, err = br.ReadBytes('\n')
+			line = append(line, more...)
+		}
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("line %d: %w", lineNo, err)
+		}
+
+		line = bytes.TrimSuffix(line, []byte{'\n'})
+		line = bytes.TrimSuffix(line, []byte{'\r'})
+		if len(line) != 0 && line[0] != '#' {
+			if perr := ti.addLine(line); perr != nil {
+				return fmt.Errorf("line %d: %w", lineNo, perr)
+			}
+		}
+
+		if err == io.EOF {
+			ti.finish()
+			return nil
+		}
+	}
+}
+
+// textImport collects the strings read by ImportText or ImportJSONL, so that
+// they can all be checked before any is added.
+type textImport struct {
+	m    *SymbolTab
+	vals []string
+	seen map[string]uint32
+}
+
+// addLine adds the string from a line of ExportText's output.
+func (ti *textImport) addLine(line []byte) error {
+	seqText, val, ok := bytes.Cut(line, []byte{'\t'})
+	if !ok {
+		return errors.New("expected a sequence number and a tab")
+	}
+	seq, err := strconv.ParseUint(string(seqText), 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid sequence number %q", seqText)
+	}
+
+	if len(val) > 0 && val[0] == '"' {
+		unquoted, err := strconv.Unquote(string(val))
+		if err != nil {
+			return fmt.Errorf("invalid quoted string %s", val)
+		}
+		return ti.add(uint32(seq), unquoted)
+	}
+	return ti.add(uint32(seq), string(val))
+}
+
+// add adds val with sequence number seq, which must be the next sequence
+// number, to the strings to import.
+func (ti *textImport) add(seq uint32, val string) error {
+	if expected := uint32(ti.m.count + len(ti.vals) + 1); seq != expected {
+		if seq < expected {
+			return fmt.Errorf("sequence number %d is out of order or repeated: expected %d", seq, expected)
+		}
+		return fmt.Errorf("gap in sequence numbers: expected %d, got %d", expected, seq)
+	}
+	existing, found := ti.m.StringToSequence(val, false)
+	if !found {
+		existing, found = ti.seen[val]
+	}
+	if found {
+		return fmt.Errorf("string %q at sequence %d is a duplicate of sequence %d", val, seq, existing)
+	}
+	if ti.seen == nil {
+		ti.seen = make(map[string]uint32)
+	}
+	ti.seen[val] = seq
+	ti.vals = append(ti.vals, val)
+	return nil
+}
+
+// finish adds the strings to the SymbolTab.
+func (ti *textImport) finish() {
+	for _, val := range ti.vals {
+		ti.m.StringToSequence(val, true)
+	}
+}
+
+// jsonRecord is a line of JSON Lines. Strings that are valid UTF-8 are in S.
+// Other strings are base64 encoded in B64.
+type jsonRecord struct {
+	Seq uint32  `json:"seq"`
+	S   *string `json:"s,omitempty"`
+	B64 *string `json:"b64,omitempty"`
+}
+
+// ExportJSONL writes the strings in the SymbolTab to w as JSON Lines, in
+// sequence order. Each line is an object like {"seq":1,"s":"hat"}. JSON can
+// only hold valid UTF-8, so other strings are written base64 encoded, like
+// {"seq":2,"b64":"/w=="}.
+func (m *SymbolTab) ExportJSONL(w io.Writer) error {
+	if m.deleted > 0 {
+		return ErrHasDeletions
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	for seq := uint32(1); seq <= uint32(m.count); seq++ {
+		val := m.SequenceToString(seq)
+		rec := jsonRecord{Seq: seq}
+		if utf8.ValidString(val) {
+			rec.S = &val
+		} else {
+			b64 := base64.StdEncoding.EncodeToString([]byte(val))
+			rec.B64 = &b64
+		}
+		if err := enc.Encode(&rec); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ImportJSONL reads strings written by ExportJSONL and adds them to the
+// SymbolTab. The rules are the same as for ImportText.
+func (m *SymbolTab) ImportJSONL(r io.Reader) error {
+	if m.frozen {
+		return ErrReadOnly
+	}
+	defer m.pauseReuse()()
+	ti := textImport{m: m}
+	dec := json.NewDecoder(r)
+	for recNo := 1; ; recNo++ {
+		var rec jsonRecord
+		if err := dec.Decode(&rec); err != nil {
+			if err == io.EOF {
+				ti.finish()
+				return nil
+			}
+			return fmt.Errorf("record %d: %w", recNo, err)
+		}
+
+		var val string
+		switch {
+		case rec.S != nil && rec.B64 == nil:
+			val = *rec.S
+		case rec.B64 != nil && rec.S == nil:
+			data, err := base64.StdEncoding.DecodeString(*rec.B64)
+			if err != nil {
+				return fmt.Errorf("record %d: invalid base64: %w", recNo, err)
+			}
+			val = string(data)
+		default:
+			return fmt.Errorf("record %d: expected exactly one of s and b64", recNo)
+		}
+
+		if err := ti.add(rec.Seq, val); err != nil {
+			return fmt.Errorf("record %d: %w", recNo, err)
+		}
+	}
+}
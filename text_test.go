@@ -0,0 +1,220 @@
+package swisssymbols
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var awkwardStrings = []string{
+	"hat",
+	"",
+	"line\nbreak",
+	"carriage\rreturn",
+	"\"quoted\"",
+	"tab\there",
+	"  spaces  ",
+	"#not a comment",
+	"日本語",
+	"\xff\xfe invalid",
+	"<html> & stuff",
+	strings.Repeat("long", 2000),
+}
+
+func TestTextRoundTrip(t *testing.T) {
+	st := New()
+	defer st.Close()
+	for _, val := range awkwardStrings {
+		st.StringToSequence(val, true)
+	}
+	for i := range 1000 {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+
+	tests := []struct {
+		name   string
+		export func(st *SymbolTab, buf *bytes.Buffer) error
+		imp    func(st *SymbolTab, buf *bytes.Buffer) error
+	}{
+		{
+			name:   "text",
+			export: func(st *SymbolTab, buf *bytes.Buffer) error { return st.ExportText(buf) },
+			imp:    func(st *SymbolTab, buf *bytes.Buffer) error { return st.ImportText(buf) },
+		},
+		{
+			name:   "jsonl",
+			export: func(st *SymbolTab, buf *bytes.Buffer) error { return st.ExportJSONL(buf) },
+			imp:    func(st *SymbolTab, buf *bytes.Buffer) error { return st.ImportJSONL(buf) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tt.export(st, &buf); err != nil {
+				t.Fatal(err)
+			}
+			if lines := strings.Count(buf.String(), "\n"); lines != st.Len() {
+				t.Fatalf("expected %d lines, got %d", st.Len(), lines)
+			}
+
+			imported := New()
+			defer imported.Close()
+			if err := tt.imp(imported, &buf); err != nil {
+				t.Fatal(err)
+			}
+			assertSameSymbols(t, st, imported)
+		})
+	}
+}
+
+func TestExportText(t *testing.T) {
+	st := New()
+	defer st.Close()
+	st.StringToSequence("hat", true)
+	st.StringToSequence("two\nlines", true)
+	st.StringToSequence("\xff", true)
+
+	var buf bytes.Buffer
+	if err := st.ExportText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := "1\that\n2\t\"two\\nlines\"\n3\t\"\\xff\"\n"
+	if buf.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := st.ExportJSONL(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected = `{"seq":1,"s":"hat"}
+{"seq":2,"s":"two\nlines"}
+{"seq":3,"b64":"/w=="}
+`
+	if buf.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestImportText(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+		len   int
+	}{
+		{
+			name:  "hand edited",
+			input: "# a comment\r\n1\that\r\n\r\n2\tcoat\n3\t\"scarf\"",
+			len:   3,
+		},
+		{
+			name:  "gap",
+			input: "1\that\n3\tcoat\n",
+			err:   "line 2: gap in sequence numbers: expected 2, got 3",
+		},
+		{
+			name:  "repeat",
+			input: "1\that\n1\tcoat\n",
+			err:   "line 2: sequence number 1 is out of order or repeated: expected 2",
+		},
+		{
+			name:  "duplicate",
+			input: "1\that\n2\tcoat\n3\that\n",
+			err:   `line 3: string "hat" at sequence 3 is a duplicate of sequence 1`,
+		},
+		{
+			name:  "no tab",
+			input: "1 hat\n",
+			err:   "line 1: expected a sequence number and a tab",
+		},
+		{
+			name:  "bad seq",
+			input: "one\that\n",
+			err:   `line 1: invalid sequence number "one"`,
+		},
+		{
+			name:  "bad quoting",
+			input: "1\t\"hat\n",
+			err:   `line 1: invalid quoted string "hat`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := New()
+			defer st.Close()
+			err := st.ImportText(strings.NewReader(tt.input))
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else if err == nil || err.Error() != tt.err {
+				t.Fatalf("expected error %q, got %v", tt.err, err)
+			}
+			if st.Len() != tt.len {
+				t.Fatalf("expected %d strings, got %d", tt.len, st.Len())
+			}
+		})
+	}
+}
+
+func TestImportJSONL(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{
+			name:  "good",
+			input: `{"seq":1,"s":"hat"}` + "\n" + `{"seq":2,"b64":"/w=="}`,
+		},
+		{
+			name:  "gap",
+			input: `{"seq":1,"s":"hat"}` + "\n" + `{"seq":3,"s":"coat"}`,
+			err:   "record 2: gap in sequence numbers: expected 2, got 3",
+		},
+		{
+			name:  "duplicate",
+			input: `{"seq":1,"s":"hat"}` + "\n" + `{"seq":2,"s":"hat"}`,
+			err:   `record 2: string "hat" at sequence 2 is a duplicate of sequence 1`,
+		},
+		{
+			name:  "both",
+			input: `{"seq":1,"s":"hat","b64":"/w=="}`,
+			err:   "record 1: expected exactly one of s and b64",
+		},
+		{
+			name:  "neither",
+			input: `{"seq":1}`,
+			err:   "record 1: expected exactly one of s and b64",
+		},
+		{
+			name:  "bad base64",
+			input: `{"seq":1,"b64":"!!"}`,
+			err:   "record 1: invalid base64: illegal base64 data at input byte 0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := New()
+			defer st.Close()
+			err := st.ImportJSONL(strings.NewReader(tt.input))
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				if st.Len() != 0 {
+					t.Fatalf("expected nothing to be imported, have %d strings", st.Len())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package swisssymbols
+
+import "iter"
+
+// View is a point-in-time view of a SymbolTab. It only sees the strings with
+// sequence numbers up to a watermark. New strings get sequence numbers above
+// the watermark, so the View stays the same while more strings are added to
+// the SymbolTab.
+//
+// A View is only point-in-time for additions. Strings deleted from the
+// SymbolTab disappear from the View, and its Len goes down, even if they are
+// deleted after the View was made. And unless the SymbolTab was created
+// WithReaders, the View must not be used while another goroutine adds
+// strings, though it stays the same across strings added between uses. Strings
+// can't be deleted from a SymbolTab created WithReaders, so a View of one has
+// neither limit.
+type View struct {
+	m *SymbolTab
+	// r is set if the SymbolTab has Readers, in which case we read through
+	// it.
+	r    *Reader
+	upTo uint32
+}
+
+// View returns a View of the SymbolTab that only sees strings with sequence
+// numbers up to upTo. If upTo is more than the number of strings in the
+// SymbolTab it is reduced to that number, so that strings added later stay
+// out of the View.
+//
+// If the SymbolTab was created with WithReaders, View may be called from any
+// goroutine, and the View reads the SymbolTab through its own Reader. So it
+// may be used on another goroutine while strings are added, and the same rules
+// apply as for a Reader. Otherwise the View must not be used at the same time
+// as the SymbolTab is changed, and deleting strings up to upTo changes the
+// View. Close the View when it is no longer needed.
+//
+// View panics if the SymbolTab was created WithSequenceReuse, as then a new
+// string could take a sequence number inside the View.
+func (m *SymbolTab) View(upTo uint32) *View {
+	if m.reuseSeqs {
+		panic("swisssymbols: View called on a SymbolTab created WithSequenceReuse")
+	}
+	if m.readers != nil {
+		r := m.NewReader()
+		return &View{m: m, r: r, upTo: min(upTo, uint32(r.Len()))}
+	}
+	return &View{m: m, upTo: min(upTo, uint32(m.count))}
+}
+
+// Close releases the View's Reader, if it has one.
+func (v *View) Close() error {
+	if v.r != nil {
+		return v.r.Close()
+	}
+	return nil
+}
+
+// Len returns the number of strings in the View. Deleted strings are not
+// counted.
+func (v *View) Len() int {
+	if v.r != nil {
+		// Strings can't be deleted from a SymbolTab with Readers
+		return int(v.upTo)
+	}
+	return int(v.upTo) - v.m.deletedUpTo(v.upTo)
+}
+
+// SequenceToString looks up a string by its sequence number. It returns an
+// empty string if the sequence number is beyond the View.
+func (v *View) SequenceToString(seq uint32) string {
+	if seq == 0 || seq > v.upTo {
+		return ""
+	}
+	if v.r != nil {
+		return v.r.SequenceToString(seq)
+	}
+	return v.m.SequenceToString(seq)
+}
+
+// StringToSequence looks up the string val and returns its sequence number.
+// found is false if val is not in the View, even if it has since been added to
+// the SymbolTab. A View can't add strings, so if val is not found and addNew
+// is true StringToSequence panics with ErrReadOnly.
+func (v *View) StringToSequence(val string, addNew bool) (seq uint32, found bool) {
+	if v.r != nil {
+		seq, found = v.r.StringToSequence(val, false)
+	} else {
+		seq, found = v.m.StringToSequence(val, false)
+	}
+	if seq > v.upTo {
+		seq, found = 0, false
+	}
+	if !found && addNew {
+		panic(ErrReadOnly)
+	}
+	return seq, found
+}
+
+// All returns an iterator over the strings in the View and their sequence
+// numbers, in sequence number order. Deleted strings are skipped.
+func (v *View) All() iter.Seq2[uint32, string] {
+	if v.r == nil {
+		return v.m.Range(1, v.upTo)
+	}
+	return func(yield func(uint32, string) bool) {
+		for seq := uint32(1); seq <= v.upTo; seq++ {
+			if !yield(seq, v.SequenceToString(seq)) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package swisssymbols
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestView(t *testing.T) {
+	st := New()
+	defer st.Close()
+	for i := range 1000 {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+
+	v := st.View(500)
+	defer v.Close()
+	for i := 1000; i < 2000; i++ {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	assertView(t, v, 500)
+
+	// A watermark beyond the end is reduced to the number of strings.
+	v2 := st.View(10_000)
+	defer v2.Close()
+	st.StringToSequence("later", true)
+	assertView(t, v2, 2000)
+}
+
+func TestViewDelete(t *testing.T) {
+	st := New()
+	defer st.Close()
+	for i := range 10 {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	v := st.View(5)
+	defer v.Close()
+
+	// Deleting strings inside the View removes them from it. Deleting
+	// strings beyond it makes no difference.
+	st.DeleteSequence(2)
+	st.DeleteSequence(8)
+	if v.Len() != 4 {
+		t.Fatalf("expected 4 strings in view, have %d", v.Len())
+	}
+	if s := v.SequenceToString(2); s != "" {
+		t.Fatalf("expected deleted string to be gone, got %q", s)
+	}
+	if seq, found := v.StringToSequence("1", false); found {
+		t.Fatalf("found deleted string at %d", seq)
+	}
+	var count int
+	for range v.All() {
+		count++
+	}
+	if count != 4 {
+		t.Fatalf("expected All to give 4 strings, got %d", count)
+	}
+
+	// Re-adding a deleted string gives it a new sequence number beyond the
+	// View.
+	if seq, _ := st.StringToSequence("1", true); seq != 11 {
+		t.Fatalf("expected 11, got %d", seq)
+	}
+	if seq, found := v.StringToSequence("1", false); found {
+		t.Fatalf("found re-added string at %d", seq)
+	}
+}
+
+func TestViewSequenceReuse(t *testing.T) {
+	st := New(WithSequenceReuse())
+	defer st.Close()
+	defer func() {
+		if p := recover(); p == nil {
+			t.Fatal("expected a panic")
+		}
+	}()
+	st.View(1)
+}
+
+func TestViewReaders(t *testing.T) {
+	st := New(WithReaders())
+	defer st.Close()
+	for i := range 100_000 {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+
+	// The writer carries on adding strings while we look at a View on
+	// another goroutine.
+	v := st.View(50_000)
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		defer v.Close()
+		for range 3 {
+			assertView(t, v, 50_000)
+		}
+	})
+	for i := 100_000; i < 300_000; i++ {
+		st.StringToSequence(strconv.Itoa(i), true)
+	}
+	wg.Wait()
+}
+
+// assertView checks that v holds the strings "0" to upTo-1
+func assertView(t *testing.T, v *View, upTo int) {
+	t.Helper()
+	if v.Len() != upTo {
+		t.Errorf("expected %d strings in view, have %d", upTo, v.Len())
+		return
+	}
+	for i := range upTo + 100 {
+		val := strconv.Itoa(i)
+		seq, found := v.StringToSequence(val, false)
+		if i < upTo && (!found || seq != uint32(i+1)) {
+			t.Errorf("looking up %q gave %d, %t", val, seq, found)
+			return
+		}
+		if i >= upTo && (found || seq != 0) {
+			t.Errorf("found %q as %d, but it is beyond the view", val, seq)
+			return
+		}
+		if got := v.SequenceToString(uint32(i + 1)); i < upTo && got != val || i >= upTo && got != "" {
+			t.Errorf("seq %d gives %q", i+1, got)
+			return
+		}
+	}
+
+	var count int
+	for seq, val := range v.All() {
+		if val != strconv.Itoa(int(seq)-1) {
+			t.Errorf("seq %d gives %q", seq, val)
+			return
+		}
+		count++
+	}
+	if count != upTo {
+		t.Errorf("iterated over %d strings, expected %d", count, upTo)
+	}
+}